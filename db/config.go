@@ -1,15 +1,40 @@
 package db
 
+import "time"
+
 type Config struct {
     Host    string  `yaml:"host"`
     Name    string  `yaml:"name"`
     User    string  `yaml:"user"`
     Password string `yaml:"password"`
     Port    int     `yaml:"port"`
+
+    // read replicas, only used by mysql
+    Replicas            []*ReplicaConfig    `yaml:"replicas"`
+    ReplicaPolicy       string              `yaml:"replica_policy"`
+    HealthCheckInterval time.Duration       `yaml:"health_check_interval"`
+
+    // statements slower than this are logged, 0 disables the slow query log
+    SlowQueryThreshold  time.Duration       `yaml:"slow_query_threshold"`
 }
 
+// ReplicaConfig falls back to the primary's user and password when they are empty
+type ReplicaConfig struct {
+    Host        string  `yaml:"host"`
+    Port        int     `yaml:"port"`
+    User        string  `yaml:"user"`
+    Password    string  `yaml:"password"`
+}
+
+const (
+    RoundRobin      = "round_robin"
+    LeastConn       = "least_conn"
+)
+
 func NewConfig() *Config {
     return &Config{
-
+        ReplicaPolicy: RoundRobin,
+        HealthCheckInterval: 10 * time.Second,
+        SlowQueryThreshold: 200 * time.Millisecond,
     }
 }
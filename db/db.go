@@ -1,25 +1,62 @@
 package db
 
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrNotInitialized = errors.New("db is not initialized")
+
 type DB interface {
 	InitDB() error
 	Close() error
+	Health(ctx context.Context) error
+}
+
+// SQLDB is implemented by the database/sql based backends (mysql, sqlite)
+type SQLDB interface {
+	DB
+	SqlDB() *sql.DB
+	DriverName() string
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	// Begin is BeginTx with the statements of the transaction counted by QueryMetrics
+	Begin(ctx context.Context, opts *sql.TxOptions) (*Tx, error)
+	Querier
+	QueryMetrics() *QueryMetrics
 }
 
 func NewDB(driver string) DB {
+	return NewDBWithConfig(driver, NewConfig())
+}
+
+// NewDBWithConfig uses NewConfig if config is nil
+func NewDBWithConfig(driver string, config *Config) DB {
+	if config == nil {
+		config = NewConfig()
+	}
+
 	switch driver {
 	case "mysql":
 		return &mysql{
-
+			sqlConn: newSqlConn("mysql", config),
 		}
 	case "mongodb":
-
-		return nil
+		return &mongodb{config: config}
 	case "leveldb":
 
 		return nil
 	case "sqlite":
-
-		return nil
+		return &sqlite{
+			sqlConn: newSqlConn("sqlite3", config),
+		}
 	case "redis":
 
 		return nil
@@ -29,34 +66,217 @@ func NewDB(driver string) DB {
 	}
 }
 
-// mysql
-type mysql struct {
+// sqlConn is shared by the database/sql based backends
+type sqlConn struct {
+	driver  string
+	config  *Config
+	metrics *QueryMetrics
 
+	// guards db and replicas, which are set again when a Monitor retries a failed InitDB
+	mu       sync.RWMutex
+	db       *sql.DB
+	replicas *replicaSet
 }
 
-func (m *mysql) InitDB() error {
+func newSqlConn(driver string, config *Config) sqlConn {
+	if config == nil {
+		config = NewConfig()
+	}
+	return sqlConn{
+		driver:  driver,
+		config:  config,
+		metrics: newQueryMetrics(config.SlowQueryThreshold),
+	}
+}
+
+func (s *sqlConn) open(dsn string) error {
+	db, err := sql.Open(s.driver, dsn)
+	if err != nil {
+		return err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.db = db
+	s.mu.Unlock()
 
 	return nil
 }
 
-func (m *mysql) Close() error {
+func (s *sqlConn) SqlDB() *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db
+}
 
-	return nil
+func (s *sqlConn) DriverName() string {
+	return s.driver
+}
+
+// Health pings the primary, database/sql replaces broken connections so a successful ping means it is usable again
+func (s *sqlConn) Health(ctx context.Context) error {
+	db := s.SqlDB()
+	if db == nil {
+		return ErrNotInitialized
+	}
+	return db.PingContext(ctx)
 }
 
-// mongodb
-type mongodb struct {
+func (s *sqlConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	db := s.SqlDB()
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	return db.BeginTx(ctx, opts)
+}
+
+func (s *sqlConn) Begin(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := s.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, metrics: s.metrics}, nil
+}
 
+func (s *sqlConn) QueryMetrics() *QueryMetrics {
+	return s.metrics
 }
 
-func (m *mongodb) InitDB() error {
-	return nil
+func (s *sqlConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db := s.SqlDB()
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+
+	start := time.Now()
+	ret, err := db.ExecContext(ctx, query, args...)
+	s.metrics.observe(&QueryEvent{Op: OpExec, Query: query, Args: args, Elapsed: time.Since(start), Err: err})
+
+	return ret, err
+}
+
+// QueryContext is sent to a read replica if there is a healthy one, unless ctx is marked by WithPrimary
+func (s *sqlConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db := s.reader(ctx)
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+
+	start := time.Now()
+	rows, err := db.QueryContext(ctx, query, args...)
+	s.metrics.observe(&QueryEvent{Op: OpQuery, Query: query, Args: args, Elapsed: time.Since(start), Err: err})
+
+	return rows, err
+}
+
+// QueryRowContext returns a row whose Scan fails with ErrNotInitialized before InitDB
+func (s *sqlConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db := s.reader(ctx)
+	if db == nil {
+		return uninitializedDB.QueryRowContext(ctx, query, args...)
+	}
+
+	start := time.Now()
+	row := db.QueryRowContext(ctx, query, args...)
+	s.metrics.observe(&QueryEvent{Op: OpQuery, Query: query, Args: args, Elapsed: time.Since(start), Err: row.Err()})
+
+	return row
+}
+
+// sql.Row can only be made by a *sql.DB, uninitializedDB fails every connection with ErrNotInitialized
+var uninitializedDB = sql.OpenDB(uninitializedConnector{})
+
+type uninitializedConnector struct{}
+
+func (uninitializedConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, ErrNotInitialized
+}
+
+func (c uninitializedConnector) Driver() driver.Driver {
+	return c
+}
+
+func (uninitializedConnector) Open(string) (driver.Conn, error) {
+	return nil, ErrNotInitialized
+}
+
+func (s *sqlConn) reader(ctx context.Context) *sql.DB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.replicas == nil || isPrimaryForced(ctx) {
+		return s.db
+	}
+	if db := s.replicas.pick(); db != nil {
+		return db
+	}
+	return s.db
+}
+
+func (s *sqlConn) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.replicas != nil {
+		_ = s.replicas.close()
+		s.replicas = nil
+	}
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// mysql
+type mysql struct {
+	sqlConn
 }
 
-func (m *mongodb) Close() error {
+func (m *mysql) InitDB() error {
+	if err := m.open(m.dsn(m.config.Host, m.config.Port, m.config.User, m.config.Password)); err != nil {
+		return err
+	}
+	if len(m.config.Replicas) == 0 {
+		return nil
+	}
+
+	replicas := make([]*replica, 0, len(m.config.Replicas))
+	for _, rc := range m.config.Replicas {
+		user, password := rc.User, rc.Password
+		if user == "" {
+			user, password = m.config.User, m.config.Password
+		}
+		db, err := sql.Open(m.driver, m.dsn(rc.Host, rc.Port, user, password))
+		if err != nil {
+			for _, r := range replicas {
+				_ = r.db.Close()
+			}
+			return err
+		}
+		// an unreachable replica doesn't fail InitDB, it is skipped until a health check passes
+		r := &replica{addr: fmt.Sprintf("%s:%d", rc.Host, rc.Port), db: db}
+		r.setHealthy(db.Ping() == nil)
+		replicas = append(replicas, r)
+	}
+
+	rs := newReplicaSet(m.config.ReplicaPolicy, replicas)
+	rs.start(m.config.HealthCheckInterval)
+
+	m.mu.Lock()
+	m.replicas = rs
+	m.mu.Unlock()
+
 	return nil
 }
 
+func (m *mysql) dsn(host string, port int, user, password string) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=true",
+		user, password, host, port, m.config.Name)
+}
+
 // leveldb
 type leveldb struct {
 
@@ -70,17 +290,21 @@ func (l *leveldb) Close() error {
 	return nil
 }
 
-// sqlite
-type sqlite struct {
+func (l *leveldb) Health(ctx context.Context) error {
+	return nil
+}
 
+// sqlite, Config.Name is the path of the database file
+type sqlite struct {
+	sqlConn
 }
 
 func (s *sqlite) InitDB() error {
-	return nil
-}
+	if s.config.Name == "" {
+		return errors.New("sqlite database file should not be empty")
+	}
 
-func (s *sqlite) Close() error {
-	return nil
+	return s.open(s.config.Name)
 }
 
 // redis
@@ -95,3 +319,7 @@ func (r *redis) InitDB() error {
 func (r *redis) Close() error {
 	return nil
 }
+
+func (r *redis) Health(ctx context.Context) error {
+	return nil
+}
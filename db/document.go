@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrNoDocuments = errors.New("no documents in result")
+
+// Document is a filter, update or stored document, nested documents are Document as well
+type Document map[string]interface{}
+
+// DocumentStore is implemented by the mongodb backend and by NewMemoryStore
+type DocumentStore interface {
+	DB
+	Collection(name string) Collection
+}
+
+/*
+filter使用mongo的查询语法，支持字段等值以及$eq $ne $gt $gte $lt $lte $in $nin $exists $and $or $nor，
+字段名可以用a.b访问嵌套文档。update支持$set $unset $inc。
+*/
+type Collection interface {
+	// InsertOne returns the _id of the document, one is generated if missing
+	InsertOne(ctx context.Context, doc Document) (interface{}, error)
+	InsertMany(ctx context.Context, docs []Document) ([]interface{}, error)
+	// FindOne returns ErrNoDocuments if nothing matches
+	FindOne(ctx context.Context, filter Document) (Document, error)
+	Find(ctx context.Context, filter Document, opts *FindOptions) ([]Document, error)
+	CountDocuments(ctx context.Context, filter Document) (int64, error)
+	// UpdateOne and UpdateMany return the number of matched documents
+	UpdateOne(ctx context.Context, filter Document, update Document) (int64, error)
+	UpdateMany(ctx context.Context, filter Document, update Document) (int64, error)
+	DeleteOne(ctx context.Context, filter Document) (int64, error)
+	DeleteMany(ctx context.Context, filter Document) (int64, error)
+
+	CreateIndex(ctx context.Context, index Index) (string, error)
+	DropIndex(ctx context.Context, name string) error
+	ListIndexes(ctx context.Context) ([]Index, error)
+}
+
+const (
+	Asc  = 1
+	Desc = -1
+)
+
+type FieldOrder struct {
+	Field string
+	Order int
+}
+
+type FindOptions struct {
+	Sort  []FieldOrder
+	Skip  int64
+	Limit int64
+}
+
+type Index struct {
+	// generated from Keys if empty, e.g. name_1_age_-1
+	Name   string
+	Keys   []FieldOrder
+	Unique bool
+}
+
+func (i Index) name() string {
+	if i.Name != "" {
+		return i.Name
+	}
+	parts := make([]string, 0, len(i.Keys)*2)
+	for _, k := range i.Keys {
+		parts = append(parts, k.Field, fmt.Sprint(k.Order))
+	}
+	return strings.Join(parts, "_")
+}
+
+func checkUpdate(update Document) error {
+	if len(update) == 0 {
+		return errors.New("update document should not be empty")
+	}
+	for op := range update {
+		if !strings.HasPrefix(op, "$") {
+			return errors.New("update document requires operators such as $set")
+		}
+	}
+	return nil
+}
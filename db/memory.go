@@ -0,0 +1,639 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+内存实现的DocumentStore，和mongodb backend使用相同的接口，用于测试。
+数据只保存在进程内存中，Close之后清空。
+*/
+func NewMemoryStore() DocumentStore {
+	return &memoryStore{
+		collections: make(map[string]*memoryCollection),
+	}
+}
+
+type memoryStore struct {
+	mu          sync.Mutex
+	collections map[string]*memoryCollection
+}
+
+func (m *memoryStore) InitDB() error {
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	m.mu.Lock()
+	m.collections = make(map[string]*memoryCollection)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *memoryStore) Health(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryStore) Collection(name string) Collection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	coll, ok := m.collections[name]
+	if !ok {
+		coll = &memoryCollection{
+			indexes: []Index{{Name: "_id_", Keys: []FieldOrder{{Field: "_id", Order: Asc}}, Unique: true}},
+		}
+		m.collections[name] = coll
+	}
+	return coll
+}
+
+type memoryCollection struct {
+	mu      sync.RWMutex
+	docs    []Document
+	indexes []Index
+}
+
+func (c *memoryCollection) InsertOne(ctx context.Context, doc Document) (interface{}, error) {
+	ids, err := c.InsertMany(ctx, []Document{doc})
+	if err != nil {
+		return nil, err
+	}
+	return ids[0], nil
+}
+
+func (c *memoryCollection) InsertMany(ctx context.Context, docs []Document) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		doc = copyValue(doc).(Document)
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+		if err := c.checkUnique(doc, -1); err != nil {
+			return ids, err
+		}
+		c.docs = append(c.docs, doc)
+		ids = append(ids, doc["_id"])
+	}
+	return ids, nil
+}
+
+func (c *memoryCollection) FindOne(ctx context.Context, filter Document) (Document, error) {
+	docs, err := c.Find(ctx, filter, &FindOptions{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, ErrNoDocuments
+	}
+	return docs[0], nil
+}
+
+func (c *memoryCollection) Find(ctx context.Context, filter Document, opts *FindOptions) ([]Document, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]Document, 0)
+	for _, doc := range c.docs {
+		ok, err := matchDocument(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, copyValue(doc).(Document))
+		}
+	}
+	if opts == nil {
+		return ret, nil
+	}
+
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(ret, func(i, j int) bool {
+			for _, s := range opts.Sort {
+				vi, _ := lookupField(ret[i], s.Field)
+				vj, _ := lookupField(ret[j], s.Field)
+				if cmp := compareValues(vi, vj); cmp != 0 {
+					return cmp*s.Order < 0
+				}
+			}
+			return false
+		})
+	}
+	if opts.Skip > 0 {
+		if opts.Skip >= int64(len(ret)) {
+			return ret[:0], nil
+		}
+		ret = ret[opts.Skip:]
+	}
+	if opts.Limit > 0 && opts.Limit < int64(len(ret)) {
+		ret = ret[:opts.Limit]
+	}
+	return ret, nil
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter Document) (int64, error) {
+	docs, err := c.Find(ctx, filter, nil)
+	return int64(len(docs)), err
+}
+
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter Document, update Document) (int64, error) {
+	return c.update(filter, update, false)
+}
+
+func (c *memoryCollection) UpdateMany(ctx context.Context, filter Document, update Document) (int64, error) {
+	return c.update(filter, update, true)
+}
+
+func (c *memoryCollection) update(filter Document, update Document, multi bool) (int64, error) {
+	if err := checkUpdate(update); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matched int64
+	for i, doc := range c.docs {
+		ok, err := matchDocument(doc, filter)
+		if err != nil {
+			return matched, err
+		}
+		if !ok {
+			continue
+		}
+
+		updated := copyValue(doc).(Document)
+		if err := applyUpdate(updated, update); err != nil {
+			return matched, err
+		}
+		if err := c.checkUnique(updated, i); err != nil {
+			return matched, err
+		}
+		c.docs[i] = updated
+
+		matched++
+		if !multi {
+			break
+		}
+	}
+	return matched, nil
+}
+
+func (c *memoryCollection) DeleteOne(ctx context.Context, filter Document) (int64, error) {
+	return c.delete(filter, false)
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter Document) (int64, error) {
+	return c.delete(filter, true)
+}
+
+func (c *memoryCollection) delete(filter Document, multi bool) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var deleted int64
+	kept := make([]Document, 0, len(c.docs))
+	for _, doc := range c.docs {
+		if multi || deleted == 0 {
+			ok, err := matchDocument(doc, filter)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				deleted++
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return deleted, nil
+}
+
+func (c *memoryCollection) CreateIndex(ctx context.Context, index Index) (string, error) {
+	if len(index.Keys) == 0 {
+		return "", errors.New("index keys should not be empty")
+	}
+	index.Name = index.name()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, idx := range c.indexes {
+		if idx.Name == index.Name {
+			return idx.Name, nil
+		}
+	}
+
+	c.indexes = append(c.indexes, index)
+	for i, doc := range c.docs {
+		if err := c.checkUnique(doc, i); err != nil {
+			c.indexes = c.indexes[:len(c.indexes)-1]
+			return "", err
+		}
+	}
+	return index.Name, nil
+}
+
+func (c *memoryCollection) DropIndex(ctx context.Context, name string) error {
+	if name == "_id_" {
+		return errors.New("cannot drop _id index")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, idx := range c.indexes {
+		if idx.Name == name {
+			c.indexes = append(c.indexes[:i], c.indexes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("index not found with name [%s]", name)
+}
+
+func (c *memoryCollection) ListIndexes(ctx context.Context) ([]Index, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]Index, len(c.indexes))
+	copy(ret, c.indexes)
+	return ret, nil
+}
+
+// checkUnique checks doc against the unique indexes, skipping the document at position self
+func (c *memoryCollection) checkUnique(doc Document, self int) error {
+	for _, idx := range c.indexes {
+		if !idx.Unique {
+			continue
+		}
+		key := indexKey(doc, idx)
+		for i, other := range c.docs {
+			if i != self && reflect.DeepEqual(key, indexKey(other, idx)) {
+				return fmt.Errorf("duplicate key error, index: %s", idx.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func indexKey(doc Document, idx Index) []interface{} {
+	key := make([]interface{}, len(idx.Keys))
+	for i, k := range idx.Keys {
+		v, _ := lookupField(doc, k.Field)
+		key[i] = normalizeNumber(v)
+	}
+	return key
+}
+
+func matchDocument(doc Document, filter Document) (bool, error) {
+	for key, cond := range filter {
+		var ok bool
+		var err error
+
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported filter operator %s", key)
+			}
+			value, exists := lookupField(doc, key)
+			ok, err = matchField(value, exists, cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc Document, op string, cond interface{}) (bool, error) {
+	filters, ok := cond.([]Document)
+	if !ok {
+		list, isList := cond.([]interface{})
+		if !isList {
+			return false, fmt.Errorf("%s should be a list of documents", op)
+		}
+		for _, item := range list {
+			f, isDoc := toDocument(item)
+			if !isDoc {
+				return false, fmt.Errorf("%s should be a list of documents", op)
+			}
+			filters = append(filters, f)
+		}
+	}
+
+	for _, f := range filters {
+		matched, err := matchDocument(doc, f)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+func matchField(value interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, isDoc := toDocument(cond)
+	if !isDoc || !isOperatorDocument(ops) {
+		return equalOrContains(value, cond), nil
+	}
+
+	for op, arg := range ops {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = equalOrContains(value, arg)
+		case "$ne":
+			ok = !equalOrContains(value, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			if !exists || !orderable(value, arg) {
+				return false, nil
+			}
+			cmp := compareValues(value, arg)
+			ok = (op == "$gt" && cmp > 0) || (op == "$gte" && cmp >= 0) ||
+				(op == "$lt" && cmp < 0) || (op == "$lte" && cmp <= 0)
+		case "$in", "$nin":
+			list := reflect.ValueOf(arg)
+			if list.Kind() != reflect.Slice {
+				return false, fmt.Errorf("%s needs an array", op)
+			}
+			for i := 0; i < list.Len() && !ok; i++ {
+				ok = equalOrContains(value, list.Index(i).Interface())
+			}
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			want, _ := arg.(bool)
+			ok = exists == want
+		default:
+			return false, fmt.Errorf("unsupported filter operator %s", op)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func isOperatorDocument(doc Document) bool {
+	for k := range doc {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// equalOrContains is true if value equals target, or value is an array containing target
+func equalOrContains(value interface{}, target interface{}) bool {
+	if equalValues(value, target) {
+		return true
+	}
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if equalValues(item, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equalValues(a, b interface{}) bool {
+	if orderable(a, b) {
+		return compareValues(a, b) == 0
+	}
+	return reflect.DeepEqual(normalizeValue(a), normalizeValue(b))
+}
+
+func applyUpdate(doc Document, update Document) error {
+	for op, arg := range update {
+		fields, ok := toDocument(arg)
+		if !ok {
+			return fmt.Errorf("%s needs a document", op)
+		}
+
+		for field, value := range fields {
+			if field == "_id" {
+				return errors.New("_id field cannot be updated")
+			}
+
+			switch op {
+			case "$set":
+				setField(doc, field, copyValue(value))
+			case "$unset":
+				unsetField(doc, field)
+			case "$inc":
+				current, _ := lookupField(doc, field)
+				sum, err := addNumbers(current, value)
+				if err != nil {
+					return err
+				}
+				setField(doc, field, sum)
+			default:
+				return fmt.Errorf("unsupported update operator %s", op)
+			}
+		}
+	}
+	return nil
+}
+
+func lookupField(doc Document, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		d, ok := toDocument(cur)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = d[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func setField(doc Document, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := toDocument(doc[part])
+		if !ok {
+			next = make(Document)
+		}
+		doc[part] = next
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = value
+}
+
+func unsetField(doc Document, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := toDocument(doc[part])
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+func toDocument(v interface{}) (Document, bool) {
+	switch d := v.(type) {
+	case Document:
+		return d, true
+	case map[string]interface{}:
+		return Document(d), true
+	case primitive.M:
+		return Document(d), true
+	}
+	return nil, false
+}
+
+// copyValue deep copies documents and arrays, maps are converted to Document
+func copyValue(v interface{}) interface{} {
+	if d, ok := toDocument(v); ok {
+		ret := make(Document, len(d))
+		for k, val := range d {
+			ret[k] = copyValue(val)
+		}
+		return ret
+	}
+
+	switch list := v.(type) {
+	case []interface{}:
+		ret := make([]interface{}, len(list))
+		for i, val := range list {
+			ret[i] = copyValue(val)
+		}
+		return ret
+	case primitive.A:
+		return copyValue([]interface{}(list))
+	}
+	return v
+}
+
+func normalizeValue(v interface{}) interface{} {
+	return normalizeNumber(copyValue(v))
+}
+
+// normalizeNumber converts every numeric type to float64 so 1 and int64(1) are equal
+func normalizeNumber(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return v
+}
+
+func orderable(a, b interface{}) bool {
+	a, b = normalizeNumber(a), normalizeNumber(b)
+	switch a.(type) {
+	case float64:
+		_, ok := b.(float64)
+		return ok
+	case string:
+		_, ok := b.(string)
+		return ok
+	case time.Time:
+		_, ok := b.(time.Time)
+		return ok
+	case bool:
+		_, ok := b.(bool)
+		return ok
+	case primitive.ObjectID:
+		_, ok := b.(primitive.ObjectID)
+		return ok
+	}
+	return false
+}
+
+// compareValues orders comparable values, others are ordered by type name so that sorting is stable
+func compareValues(a, b interface{}) int {
+	if !orderable(a, b) {
+		ta, tb := fmt.Sprintf("%T", a), fmt.Sprintf("%T", b)
+		return strings.Compare(ta, tb)
+	}
+
+	a, b = normalizeNumber(a), normalizeNumber(b)
+	switch x := a.(type) {
+	case float64:
+		y := b.(float64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case time.Time:
+		y := b.(time.Time)
+		if x.Before(y) {
+			return -1
+		} else if x.After(y) {
+			return 1
+		}
+		return 0
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		} else if !x {
+			return -1
+		}
+		return 1
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return strings.Compare(x.Hex(), y.Hex())
+	}
+	return 0
+}
+
+func addNumbers(current interface{}, delta interface{}) (interface{}, error) {
+	if current == nil {
+		current = 0
+	}
+
+	cur, d := reflect.ValueOf(current), reflect.ValueOf(delta)
+	isInt := func(v reflect.Value) bool {
+		return v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64
+	}
+	isFloat := func(v reflect.Value) bool {
+		return v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+	}
+
+	switch {
+	case isInt(cur) && isInt(d):
+		return cur.Int() + d.Int(), nil
+	case (isInt(cur) || isFloat(cur)) && (isInt(d) || isFloat(d)):
+		return normalizeNumber(current).(float64) + normalizeNumber(delta).(float64), nil
+	}
+	return nil, errors.New("cannot apply $inc to a non-numeric value")
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	coll := NewMemoryStore().Collection("users")
+
+	_, err := coll.InsertMany(ctx, []Document{
+		{"name": "alice", "age": 30, "tags": []interface{}{"admin"}, "profile": Document{"city": "beijing"}},
+		{"name": "bob", "age": 20, "profile": Document{"city": "shanghai"}},
+		{"name": "carol", "age": 40},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cases := []struct {
+		filter Document
+		expect int64
+	}{
+		{nil, 3},
+		{Document{"name": "alice"}, 1},
+		{Document{"age": Document{"$gte": 30}}, 2},
+		{Document{"age": Document{"$gt": int64(20), "$lt": 40.0}}, 1},
+		{Document{"tags": "admin"}, 1},
+		{Document{"profile.city": Document{"$in": []string{"beijing", "shanghai"}}}, 2},
+		{Document{"profile": Document{"$exists": false}}, 1},
+		{Document{"$or": []Document{{"name": "bob"}, {"age": 40}}}, 2},
+		{Document{"name": Document{"$ne": "bob"}, "age": Document{"$nin": []int{40}}}, 1},
+	}
+	for _, c := range cases {
+		n, err := coll.CountDocuments(ctx, c.filter)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if n != c.expect {
+			t.Errorf("filter %v: expect %d, got %d", c.filter, c.expect, n)
+		}
+	}
+
+	docs, err := coll.Find(ctx, nil, &FindOptions{Sort: []FieldOrder{{Field: "age", Order: Desc}}, Skip: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(docs) != 1 || docs[0]["name"] != "alice" {
+		t.Fatalf("unexpected docs: %v", docs)
+	}
+
+	n, err := coll.UpdateMany(ctx, Document{"age": Document{"$lt": 35}},
+		Document{"$inc": Document{"age": 1}, "$set": Document{"profile.vip": true}})
+	if err != nil || n != 2 {
+		t.Fatalf("update matched %d, err: %v", n, err)
+	}
+	doc, err := coll.FindOne(ctx, Document{"name": "bob"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if doc["age"] != int64(21) || doc["profile"].(Document)["vip"] != true {
+		t.Fatalf("unexpected doc after update: %v", doc)
+	}
+
+	if _, err := coll.UpdateOne(ctx, Document{"name": "bob"}, Document{"age": 1}); err == nil {
+		t.Fatal("expect error for update without operators")
+	}
+
+	if n, err := coll.DeleteMany(ctx, Document{"age": Document{"$gt": 30}}); err != nil || n != 2 {
+		t.Fatalf("deleted %d, err: %v", n, err)
+	}
+	if _, err := coll.FindOne(ctx, Document{"name": "carol"}); err != ErrNoDocuments {
+		t.Fatalf("expect ErrNoDocuments, got %v", err)
+	}
+}
+
+func TestMemoryStoreUniqueIndex(t *testing.T) {
+	ctx := context.Background()
+	coll := NewMemoryStore().Collection("users")
+
+	name, err := coll.CreateIndex(ctx, Index{Keys: []FieldOrder{{Field: "email", Order: Asc}}, Unique: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if name != "email_1" {
+		t.Fatalf("unexpected index name %s", name)
+	}
+
+	if _, err := coll.InsertOne(ctx, Document{"email": "a@example.com"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := coll.InsertOne(ctx, Document{"email": "a@example.com"}); err == nil {
+		t.Fatal("expect duplicate key error")
+	}
+
+	if err := coll.DropIndex(ctx, name); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := coll.InsertOne(ctx, Document{"email": "a@example.com"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	indexes, _ := coll.ListIndexes(ctx)
+	if len(indexes) != 1 || indexes[0].Name != "_id_" {
+		t.Fatalf("unexpected indexes: %v", indexes)
+	}
+}
+
+func TestMemoryStoreDeleteError(t *testing.T) {
+	ctx := context.Background()
+	coll := NewMemoryStore().Collection("users")
+
+	if _, err := coll.InsertMany(ctx, []Document{{"name": "bob"}, {"name": "alice"}, {"name": "carol"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// bob is matched, alice is kept and carol fails on the unsupported operator
+	filter := Document{"$or": []Document{
+		{"name": "bob"},
+		{"$and": []Document{{"name": "carol"}, {"$where": "true"}}},
+	}}
+	if _, err := coll.DeleteMany(ctx, filter); err == nil {
+		t.Fatal("expect error for unsupported operator")
+	}
+
+	for _, name := range []string{"bob", "alice", "carol"} {
+		if n, err := coll.CountDocuments(ctx, Document{"name": name}); err != nil || n != 1 {
+			t.Fatalf("expect %s to be left untouched, count %d, err: %v", name, n, err)
+		}
+	}
+}
+
+func TestMongoNotInitialized(t *testing.T) {
+	store := NewDBWithConfig("mongodb", NewConfig()).(DocumentStore)
+
+	if _, err := store.Collection("users").FindOne(context.Background(), nil); err != ErrNotInitialized {
+		t.Fatalf("expect ErrNotInitialized, got %v", err)
+	}
+}
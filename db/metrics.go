@@ -0,0 +1,144 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"basego/logger"
+)
+
+const (
+	OpQuery = "query"
+	OpExec  = "exec"
+)
+
+type QueryEvent struct {
+	Op      string
+	Query   string
+	Args    []interface{}
+	Elapsed time.Duration
+	Err     error
+}
+
+// QueryHook is called after every query and exec made through an SQL backend
+type QueryHook func(e *QueryEvent)
+
+type OpStats struct {
+	Count        int64
+	Errors       int64
+	Slow         int64
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+/*
+记录SQL backend上每个query/exec的耗时和错误数，超过慢查询阈值的语句通过logger打印，
+SQL中的字面量和参数值会被隐去。WithTx和Begin返回的Tx上执行的语句同样会被统计，
+BeginTx返回的*sql.Tx不会。
+*/
+type QueryMetrics struct {
+	slowThreshold time.Duration
+
+	mu    sync.RWMutex
+	ops   map[string]*OpStats
+	hooks []QueryHook
+}
+
+func newQueryMetrics(slowThreshold time.Duration) *QueryMetrics {
+	return &QueryMetrics{
+		slowThreshold: slowThreshold,
+		ops:           make(map[string]*OpStats),
+	}
+}
+
+func (m *QueryMetrics) AddHook(hook QueryHook) {
+	m.mu.Lock()
+	m.hooks = append(m.hooks, hook)
+	m.mu.Unlock()
+}
+
+// Stats returns a snapshot of the counters keyed by operation
+func (m *QueryMetrics) Stats() map[string]OpStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ret := make(map[string]OpStats, len(m.ops))
+	for op, st := range m.ops {
+		ret[op] = *st
+	}
+	return ret
+}
+
+func (m *QueryMetrics) observe(e *QueryEvent) {
+	if m == nil {
+		return
+	}
+	slow := m.slowThreshold > 0 && e.Elapsed >= m.slowThreshold
+
+	m.mu.Lock()
+	st, ok := m.ops[e.Op]
+	if !ok {
+		st = &OpStats{}
+		m.ops[e.Op] = st
+	}
+	st.Count++
+	st.TotalLatency += e.Elapsed
+	if e.Elapsed > st.MaxLatency {
+		st.MaxLatency = e.Elapsed
+	}
+	if e.Err != nil {
+		st.Errors++
+	}
+	if slow {
+		st.Slow++
+	}
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	if slow {
+		fields := map[string]interface{}{
+			"op":         e.Op,
+			"elapsed_ms": e.Elapsed.Milliseconds(),
+			"args":       RedactArgs(e.Args),
+		}
+		if e.Err != nil {
+			fields["error"] = e.Err.Error()
+		}
+		logger.Warn("slow query", RedactSQL(e.Query), fields)
+	}
+
+	for _, hook := range hooks {
+		hook(e)
+	}
+}
+
+var (
+	stringLiteral  = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	numericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+)
+
+// RedactSQL replaces string and numeric literals with ?
+func RedactSQL(query string) string {
+	query = stringLiteral.ReplaceAllString(query, "?")
+	return numericLiteral.ReplaceAllString(query, "?")
+}
+
+// RedactArgs keeps only the type (and length) of every argument
+func RedactArgs(args []interface{}) []string {
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			ret[i] = "NULL"
+		case string:
+			ret[i] = fmt.Sprintf("string(%d)", len(v))
+		case []byte:
+			ret[i] = fmt.Sprintf("[]byte(%d)", len(v))
+		default:
+			ret[i] = fmt.Sprintf("%T", v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryMetrics(t *testing.T) {
+	d := newTestSqlite(t)
+	ctx := context.Background()
+
+	events := make([]*QueryEvent, 0)
+	d.QueryMetrics().AddHook(func(e *QueryEvent) {
+		events = append(events, e)
+	})
+
+	_, _ = d.ExecContext(ctx, "INSERT INTO users (name) VALUES (?)", "alice")
+	_, _ = d.ExecContext(ctx, "INSERT INTO missing (name) VALUES (?)", "bob")
+	var n int
+	_ = d.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&n)
+
+	stats := d.QueryMetrics().Stats()
+	if stats[OpExec].Count != 2 || stats[OpExec].Errors != 1 || stats[OpQuery].Count != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(events) != 3 || events[1].Err == nil {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	err := WithTx(ctx, d, nil, func(tx *Tx) error {
+		if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "carol"); err != nil {
+			return err
+		}
+		return tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tx, err := d.Begin(ctx, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, _ = tx.ExecContext(ctx, "DELETE FROM users")
+	_ = tx.Rollback()
+
+	stats = d.QueryMetrics().Stats()
+	if stats[OpExec].Count != 4 || stats[OpQuery].Count != 2 {
+		t.Fatalf("expect statements in transactions to be counted: %+v", stats)
+	}
+
+	m := newQueryMetrics(time.Millisecond)
+	m.observe(&QueryEvent{Op: OpQuery, Query: "SELECT 1", Elapsed: time.Second})
+	if m.Stats()[OpQuery].Slow != 1 {
+		t.Fatal("expect slow query to be counted")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	query := RedactSQL("SELECT * FROM t1 WHERE name = 'it''s' AND age > 18 AND score = 9.5")
+	expect := "SELECT * FROM t1 WHERE name = ? AND age > ? AND score = ?"
+	if query != expect {
+		t.Fatalf("expect %s, got %s", expect, query)
+	}
+
+	args := RedactArgs([]interface{}{"secret", 1, nil, []byte("ab")})
+	if !reflect.DeepEqual(args, []string{"string(6)", "int", "NULL", "[]byte(2)"}) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestQueryNotInitialized(t *testing.T) {
+	d := NewDBWithConfig("sqlite", nil).(SQLDB)
+	ctx := context.Background()
+
+	var n int
+	if err := d.QueryRowContext(ctx, "SELECT 1").Scan(&n); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("expect ErrNotInitialized, got %v", err)
+	}
+	if _, err := d.QueryContext(ctx, "SELECT 1"); !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("expect ErrNotInitialized, got %v", err)
+	}
+}
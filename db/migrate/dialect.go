@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	"basego/logger"
+	"basego/toolkit"
+)
+
+type dialect interface {
+	createTable(table string) []string
+	// lock blocks until the migration lock is held by conn or timeout elapses, the returned owner is passed to unlock
+	lock(ctx context.Context, conn *sql.Conn, table string, timeout time.Duration, ttl time.Duration) (string, error)
+	unlock(ctx context.Context, conn *sql.Conn, table string, owner string) error
+}
+
+func newDialect(driver string) (dialect, error) {
+	switch driver {
+	case "mysql":
+		return &mysqlDialect{}, nil
+	case "sqlite3":
+		return &sqliteDialect{}, nil
+	default:
+		return nil, fmt.Errorf("migrate: unsupported driver %s", driver)
+	}
+}
+
+// mysql, GET_LOCK is held by the session so all statements have to run on the same conn
+type mysqlDialect struct{}
+
+func (d *mysqlDialect) createTable(table string) []string {
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ("+
+			"version BIGINT NOT NULL PRIMARY KEY, "+
+			"name VARCHAR(255) NOT NULL, "+
+			"applied_at DATETIME NOT NULL)", table),
+	}
+}
+
+// the lock of a crashed process is released by mysql with its session, ttl is not needed
+func (d *mysqlDialect) lock(ctx context.Context, conn *sql.Conn, table string, timeout time.Duration, ttl time.Duration) (string, error) {
+	var ret sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName(table), int(timeout.Seconds())).Scan(&ret)
+	if err != nil {
+		return "", err
+	}
+	if !ret.Valid || ret.Int64 != 1 {
+		return "", ErrLockTimeout
+	}
+	return "", nil
+}
+
+func (d *mysqlDialect) unlock(ctx context.Context, conn *sql.Conn, table string, owner string) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName(table))
+	return err
+}
+
+// sqlite has no advisory lock, a row in {table}_lock is used instead.
+// If a process dies while holding it the row is taken over once it expired.
+type sqliteDialect struct{}
+
+func (d *sqliteDialect) createTable(table string) []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`+
+			`version INTEGER NOT NULL PRIMARY KEY, `+
+			`name TEXT NOT NULL, `+
+			`applied_at TIMESTAMP NOT NULL)`, table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s_lock" (`+
+			`id INTEGER NOT NULL PRIMARY KEY, `+
+			`owner TEXT NOT NULL, `+
+			`locked_at TIMESTAMP NOT NULL, `+
+			`expires_at TIMESTAMP NOT NULL)`, table),
+	}
+}
+
+/*
+插入id为1的行获取锁，主键冲突说明锁被其他进程持有，过期的锁(进程崩溃后留下的)会被接管。
+其他错误(如表不存在、磁盘错误)直接返回，不当作锁冲突等待。
+*/
+func (d *sqliteDialect) lock(ctx context.Context, conn *sql.Conn, table string, timeout time.Duration, ttl time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	owner := toolkit.NewUUID()
+	insert := fmt.Sprintf(`INSERT INTO "%s_lock" (id, owner, locked_at, expires_at) VALUES (1, ?, ?, ?)`, table)
+	takeover := fmt.Sprintf(`UPDATE "%s_lock" SET owner = ?, locked_at = ?, expires_at = ? WHERE id = 1 AND expires_at < ?`, table)
+
+	for {
+		now := time.Now().UTC()
+		_, err := conn.ExecContext(ctx, insert, owner, now, now.Add(ttl))
+		if err == nil {
+			return owner, nil
+		}
+		if !lockHeld(err) {
+			return "", err
+		}
+
+		ret, err := conn.ExecContext(ctx, takeover, owner, now, now.Add(ttl), now)
+		if err != nil && !lockHeld(err) {
+			return "", err
+		}
+		if err == nil {
+			if n, _ := ret.RowsAffected(); n == 1 {
+				logger.Warn("take over expired migration lock", table, nil)
+				return owner, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return "", ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// unlock leaves the row alone if it was taken over by another process after expiring
+func (d *sqliteDialect) unlock(ctx context.Context, conn *sql.Conn, table string, owner string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM "%s_lock" WHERE id = 1 AND owner = ?`, table), owner)
+	return err
+}
+
+// lockHeld reports whether err means the lock row exists or another connection is writing
+func lockHeld(err error) bool {
+	var liteErr sqlite3.Error
+	if !errors.As(err, &liteErr) {
+		return false
+	}
+	return liteErr.Code == sqlite3.ErrConstraint || liteErr.Code == sqlite3.ErrBusy || liteErr.Code == sqlite3.ErrLocked
+}
+
+func lockName(table string) string {
+	return "basego_migrate_" + table
+}
@@ -0,0 +1,278 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"basego/db"
+)
+
+var (
+	ErrLockTimeout     = errors.New("migrate: timeout waiting for migration lock")
+	ErrNoDownMigration = errors.New("migrate: missing down sql")
+	ErrUnknownVersion  = errors.New("migrate: unknown version")
+)
+
+type Options struct {
+	// table recording applied versions
+	Table       string
+	LockTimeout time.Duration
+	// a sqlite lock older than LockTTL is taken to be left by a crashed process and is taken over,
+	// it has to be longer than the slowest migration
+	LockTTL time.Duration
+}
+
+func NewOptions() *Options {
+	return &Options{
+		Table:       "schema_migrations",
+		LockTimeout: 60 * time.Second,
+		LockTTL:     30 * time.Minute,
+	}
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	// the *sql.DB is resolved on every call, a Monitor may open a new one after a reconnect
+	db         db.SQLDB
+	dialect    dialect
+	options    *Options
+	migrations []*Migration
+}
+
+/*
+加载fsys根目录下的迁移文件，fsys可以是FromDir(dir)或者embed.FS(的子目录)
+*/
+func New(d db.SQLDB, fsys fs.FS, options *Options) (*Migrator, error) {
+	if d.SqlDB() == nil {
+		return nil, errors.New("migrate: db is not initialized")
+	}
+	if options == nil {
+		options = NewOptions()
+	}
+
+	dia, err := newDialect(d.DriverName())
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         d,
+		dialect:    dia,
+		options:    options,
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the latest n applied migrations
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && n > 0; i, n = i-1, n-1 {
+			if err := m.rollback(ctx, conn, versions[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Goto migrates up or down until version is the latest applied one, version 0 rolls back everything
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && versions[i] > version; i-- {
+			if err := m.rollback(ctx, conn, versions[i]); err != nil {
+				return err
+			}
+		}
+
+		for _, mg := range m.migrations {
+			if mg.Version > version {
+				break
+			}
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, mg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration along with applied versions missing from the source
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	ret := make([]*Status, 0, len(m.migrations))
+
+	err := m.withLock(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, mg := range m.migrations {
+			at, ok := applied[mg.Version]
+			ret = append(ret, &Status{Version: mg.Version, Name: mg.Name, Applied: ok, AppliedAt: at})
+			delete(applied, mg.Version)
+		}
+		for version, at := range applied {
+			ret = append(ret, &Status{Version: version, Applied: true, AppliedAt: at})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]time.Time) error) (err error) {
+	sqlDB := m.db.SqlDB()
+	if sqlDB == nil {
+		return db.ErrNotInitialized
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, stmt := range m.dialect.createTable(m.options.Table) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	owner, err := m.dialect.lock(ctx, conn, m.options.Table, m.options.LockTimeout, m.options.LockTTL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the lock must be released even if ctx was canceled halfway
+		if unlockErr := m.dialect.unlock(context.Background(), conn, m.options.Table, owner); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", m.options.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg *Migration) error {
+	query := fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", m.options.Table)
+
+	return m.run(ctx, conn, mg.Up, query, mg.Version, mg.Name, time.Now().UTC())
+}
+
+func (m *Migrator) rollback(ctx context.Context, conn *sql.Conn, version int64) error {
+	mg := m.find(version)
+	if mg == nil {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+	if mg.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, mg.Version, mg.Name)
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE version = ?", m.options.Table)
+
+	return m.run(ctx, conn, mg.Down, query, mg.Version)
+}
+
+// run executes script and records the version change in one transaction.
+// Note mysql commits DDL implicitly, so a failed mysql migration may be partially applied.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate: version %v: %w", args[0], err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for _, mg := range m.migrations {
+		if mg.Version == version {
+			return mg
+		}
+	}
+	return nil
+}
+
+func sortedVersions(applied map[int64]time.Time) []int64 {
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	return versions
+}
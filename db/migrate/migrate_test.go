@@ -0,0 +1,166 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"basego/db"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);")},
+	"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"0002_add_users.up.sql": {Data: []byte(`
+-- seed; with a comment
+INSERT INTO users (name) VALUES ('a;b');
+INSERT INTO users (name) VALUES ('c');`)},
+	"0002_add_users.down.sql":   {Data: []byte("DELETE FROM users;")},
+	"0003_create_orders.up.sql": {Data: []byte("CREATE TABLE orders (id INTEGER PRIMARY KEY);")},
+	"README.md":                 {Data: []byte("ignored")},
+}
+
+func newTestMigrator(t *testing.T) *Migrator {
+	config := db.NewConfig()
+	config.Name = filepath.Join(t.TempDir(), "migrate.db")
+
+	d := db.NewDBWithConfig("sqlite", config).(db.SQLDB)
+	if err := d.InitDB(); err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	m, err := New(d, testMigrations, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return m
+}
+
+func appliedVersions(t *testing.T, m *Migrator) []int64 {
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	versions := make([]int64, 0)
+	for _, s := range status {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestMigrator(t *testing.T) {
+	m := newTestMigrator(t)
+	ctx := context.Background()
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+	if v := appliedVersions(t, m); !reflect.DeepEqual(v, []int64{1, 2, 3}) {
+		t.Fatalf("unexpected applied versions after Up: %v", v)
+	}
+
+	var n int
+	if err := m.db.SqlDB().QueryRow("SELECT COUNT(*) FROM users WHERE name = 'a;b'").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("seed not applied, n: %d, err: %v", n, err)
+	}
+
+	// 0003 has no down sql
+	if err := m.Down(ctx, 1); err == nil {
+		t.Fatal("expect error rolling back a migration without down sql")
+	}
+
+	if err := m.Goto(ctx, 5); err == nil {
+		t.Fatal("expect error for unknown version")
+	}
+}
+
+func TestMigratorGoto(t *testing.T) {
+	m := newTestMigrator(t)
+	ctx := context.Background()
+
+	if err := m.Goto(ctx, 2); err != nil {
+		t.Fatal(err.Error())
+	}
+	if v := appliedVersions(t, m); !reflect.DeepEqual(v, []int64{1, 2}) {
+		t.Fatalf("unexpected applied versions after Goto(2): %v", v)
+	}
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err.Error())
+	}
+	if v := appliedVersions(t, m); !reflect.DeepEqual(v, []int64{1}) {
+		t.Fatalf("unexpected applied versions after Down(1): %v", v)
+	}
+
+	if err := m.Goto(ctx, 0); err != nil {
+		t.Fatal(err.Error())
+	}
+	if v := appliedVersions(t, m); len(v) != 0 {
+		t.Fatalf("unexpected applied versions after Goto(0): %v", v)
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	m := newTestMigrator(t)
+	m.options.LockTimeout = 300 * time.Millisecond
+	ctx := context.Background()
+	sqlDB := m.db.SqlDB()
+
+	// create the tables
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// a lock held by a live process blocks until the timeout
+	future := time.Now().UTC().Add(time.Hour)
+	if _, err := sqlDB.Exec(`INSERT INTO "schema_migrations_lock" (id, owner, locked_at, expires_at) VALUES (1, 'other', ?, ?)`, future, future); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := m.Status(ctx); err != ErrLockTimeout {
+		t.Fatalf("expect ErrLockTimeout, got %v", err)
+	}
+
+	// a lock left by a crashed process is taken over once expired
+	if _, err := sqlDB.Exec(`UPDATE "schema_migrations_lock" SET expires_at = ? WHERE id = 1`, time.Now().UTC().Add(-time.Minute)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := m.Status(ctx); err != nil {
+		t.Fatalf("expect the expired lock to be taken over, got %v", err)
+	}
+	var n int
+	if err := sqlDB.QueryRow(`SELECT COUNT(*) FROM "schema_migrations_lock"`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("expect the lock released, n: %d, err: %v", n, err)
+	}
+
+	// other errors are returned at once instead of waiting for the lock
+	if _, err := sqlDB.Exec(`DROP TABLE "schema_migrations_lock"`); err != nil {
+		t.Fatal(err.Error())
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+	start := time.Now()
+	if _, err := m.dialect.lock(ctx, conn, "schema_migrations", time.Minute, time.Minute); err == nil || err == ErrLockTimeout {
+		t.Fatalf("expect the insert error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expect a non constraint error not to be retried")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("INSERT INTO t VALUES ('x;y', \"z\\\";\"); /* a; b */ SELECT 1;\n-- c;\n")
+	expect := []string{"INSERT INTO t VALUES ('x;y', \"z\\\";\")", "SELECT 1"}
+	if !reflect.DeepEqual(stmts, expect) {
+		t.Fatalf("expect %q, got %q", expect, stmts)
+	}
+}
@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 迁移文件命名: {version}_{name}.up.sql / {version}_{name}.down.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// FromDir uses the migration files in dir, for an embedded filesystem pass it to New directly
+func FromDir(dir string) fs.FS {
+	return os.DirFS(dir)
+}
+
+func loadMigrations(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	mp := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if version <= 0 {
+			return nil, fmt.Errorf("migration %s: version should be greater than 0", entry.Name())
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := mp[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			mp[version] = m
+		} else if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(mp))
+	for _, m := range mp {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up sql", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// splitStatements splits a sql script on ';', ignoring those inside quotes and comments
+func splitStatements(script string) []string {
+	statements := make([]string, 0)
+	var sb strings.Builder
+
+	flush := func() {
+		if stmt := strings.TrimSpace(sb.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		sb.Reset()
+	}
+
+	var quote byte
+	for i := 0; i < len(script); i++ {
+		ch := script[i]
+
+		if quote != 0 {
+			sb.WriteByte(ch)
+			if ch == '\\' && quote != '`' && i+1 < len(script) {
+				i++
+				sb.WriteByte(script[i])
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+			sb.WriteByte(ch)
+		case ch == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+				sb.WriteByte('\n')
+			}
+		case ch == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case ch == ';':
+			flush()
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	flush()
+
+	return statements
+}
@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// mongodb, Config.Name is the database
+type mongodb struct {
+	config *Config
+
+	mu       sync.RWMutex
+	client   *mongo.Client
+	database *mongo.Database
+}
+
+func (m *mongodb) InitDB() error {
+	if m.config.Name == "" {
+		return errors.New("mongodb database name should not be empty")
+	}
+
+	opts := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%d", m.config.Host, m.config.Port))
+	if m.config.User != "" {
+		opts.SetAuth(options.Credential{
+			Username: m.config.User,
+			Password: m.config.Password,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		_ = client.Disconnect(ctx)
+		return err
+	}
+
+	m.mu.Lock()
+	m.client = client
+	m.database = client.Database(m.config.Name)
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *mongodb) Close() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.client == nil {
+		return nil
+	}
+	return m.client.Disconnect(context.Background())
+}
+
+func (m *mongodb) Health(ctx context.Context) error {
+	m.mu.RLock()
+	client := m.client
+	m.mu.RUnlock()
+
+	if client == nil {
+		return ErrNotInitialized
+	}
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// Collection returns a collection whose operations fail with ErrNotInitialized before InitDB succeeded
+func (m *mongodb) Collection(name string) Collection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.database == nil {
+		return errCollection{err: ErrNotInitialized}
+	}
+	return &mongoCollection{coll: m.database.Collection(name)}
+}
+
+// errCollection fails every operation with err
+type errCollection struct {
+	err error
+}
+
+func (c errCollection) InsertOne(ctx context.Context, doc Document) (interface{}, error) {
+	return nil, c.err
+}
+
+func (c errCollection) InsertMany(ctx context.Context, docs []Document) ([]interface{}, error) {
+	return nil, c.err
+}
+
+func (c errCollection) FindOne(ctx context.Context, filter Document) (Document, error) {
+	return nil, c.err
+}
+
+func (c errCollection) Find(ctx context.Context, filter Document, opts *FindOptions) ([]Document, error) {
+	return nil, c.err
+}
+
+func (c errCollection) CountDocuments(ctx context.Context, filter Document) (int64, error) {
+	return 0, c.err
+}
+
+func (c errCollection) UpdateOne(ctx context.Context, filter Document, update Document) (int64, error) {
+	return 0, c.err
+}
+
+func (c errCollection) UpdateMany(ctx context.Context, filter Document, update Document) (int64, error) {
+	return 0, c.err
+}
+
+func (c errCollection) DeleteOne(ctx context.Context, filter Document) (int64, error) {
+	return 0, c.err
+}
+
+func (c errCollection) DeleteMany(ctx context.Context, filter Document) (int64, error) {
+	return 0, c.err
+}
+
+func (c errCollection) CreateIndex(ctx context.Context, index Index) (string, error) {
+	return "", c.err
+}
+
+func (c errCollection) DropIndex(ctx context.Context, name string) error {
+	return c.err
+}
+
+func (c errCollection) ListIndexes(ctx context.Context) ([]Index, error) {
+	return nil, c.err
+}
+
+type mongoCollection struct {
+	coll *mongo.Collection
+}
+
+func (c *mongoCollection) InsertOne(ctx context.Context, doc Document) (interface{}, error) {
+	ret, err := c.coll.InsertOne(ctx, bson.M(doc))
+	if err != nil {
+		return nil, err
+	}
+	return ret.InsertedID, nil
+}
+
+func (c *mongoCollection) InsertMany(ctx context.Context, docs []Document) ([]interface{}, error) {
+	list := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		list[i] = bson.M(doc)
+	}
+
+	ret, err := c.coll.InsertMany(ctx, list)
+	if err != nil {
+		return nil, err
+	}
+	return ret.InsertedIDs, nil
+}
+
+func (c *mongoCollection) FindOne(ctx context.Context, filter Document) (Document, error) {
+	var doc bson.M
+	if err := c.coll.FindOne(ctx, toFilter(filter)).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoDocuments
+		}
+		return nil, err
+	}
+	return copyValue(doc).(Document), nil
+}
+
+func (c *mongoCollection) Find(ctx context.Context, filter Document, opts *FindOptions) ([]Document, error) {
+	findOpts := options.Find()
+	if opts != nil {
+		if len(opts.Sort) > 0 {
+			findOpts.SetSort(toKeys(opts.Sort))
+		}
+		if opts.Skip > 0 {
+			findOpts.SetSkip(opts.Skip)
+		}
+		if opts.Limit > 0 {
+			findOpts.SetLimit(opts.Limit)
+		}
+	}
+
+	cursor, err := c.coll.Find(ctx, toFilter(filter), findOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ret := make([]Document, 0)
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		ret = append(ret, copyValue(doc).(Document))
+	}
+	return ret, cursor.Err()
+}
+
+func (c *mongoCollection) CountDocuments(ctx context.Context, filter Document) (int64, error) {
+	return c.coll.CountDocuments(ctx, toFilter(filter))
+}
+
+func (c *mongoCollection) UpdateOne(ctx context.Context, filter Document, update Document) (int64, error) {
+	if err := checkUpdate(update); err != nil {
+		return 0, err
+	}
+	ret, err := c.coll.UpdateOne(ctx, toFilter(filter), bson.M(update))
+	if err != nil {
+		return 0, err
+	}
+	return ret.MatchedCount, nil
+}
+
+func (c *mongoCollection) UpdateMany(ctx context.Context, filter Document, update Document) (int64, error) {
+	if err := checkUpdate(update); err != nil {
+		return 0, err
+	}
+	ret, err := c.coll.UpdateMany(ctx, toFilter(filter), bson.M(update))
+	if err != nil {
+		return 0, err
+	}
+	return ret.MatchedCount, nil
+}
+
+func (c *mongoCollection) DeleteOne(ctx context.Context, filter Document) (int64, error) {
+	ret, err := c.coll.DeleteOne(ctx, toFilter(filter))
+	if err != nil {
+		return 0, err
+	}
+	return ret.DeletedCount, nil
+}
+
+func (c *mongoCollection) DeleteMany(ctx context.Context, filter Document) (int64, error) {
+	ret, err := c.coll.DeleteMany(ctx, toFilter(filter))
+	if err != nil {
+		return 0, err
+	}
+	return ret.DeletedCount, nil
+}
+
+func (c *mongoCollection) CreateIndex(ctx context.Context, index Index) (string, error) {
+	if len(index.Keys) == 0 {
+		return "", errors.New("index keys should not be empty")
+	}
+
+	return c.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    toKeys(index.Keys),
+		Options: options.Index().SetName(index.name()).SetUnique(index.Unique),
+	})
+}
+
+func (c *mongoCollection) DropIndex(ctx context.Context, name string) error {
+	_, err := c.coll.Indexes().DropOne(ctx, name)
+	return err
+}
+
+func (c *mongoCollection) ListIndexes(ctx context.Context) ([]Index, error) {
+	cursor, err := c.coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ret := make([]Index, 0)
+	for cursor.Next(ctx) {
+		var spec struct {
+			Name   string `bson:"name"`
+			Key    bson.D `bson:"key"`
+			Unique bool   `bson:"unique"`
+		}
+		if err := cursor.Decode(&spec); err != nil {
+			return nil, err
+		}
+
+		index := Index{Name: spec.Name, Unique: spec.Unique || spec.Name == "_id_"}
+		for _, e := range spec.Key {
+			order := Asc
+			if n, ok := normalizeNumber(e.Value).(float64); ok && n < 0 {
+				order = Desc
+			}
+			index.Keys = append(index.Keys, FieldOrder{Field: e.Key, Order: order})
+		}
+		ret = append(ret, index)
+	}
+	return ret, cursor.Err()
+}
+
+// a nil filter matches everything, the driver requires an empty document for it
+func toFilter(filter Document) bson.M {
+	if filter == nil {
+		return bson.M{}
+	}
+	return bson.M(filter)
+}
+
+func toKeys(keys []FieldOrder) bson.D {
+	d := make(bson.D, 0, len(keys))
+	for _, k := range keys {
+		d = append(d, primitive.E{Key: k.Field, Value: k.Order})
+	}
+	return d
+}
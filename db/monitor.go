@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type MonitorOptions struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// consecutive successes needed to mark a down backend up, and failures to mark an up backend down
+	RiseThreshold int
+	FallThreshold int
+	// checks of a failing backend back off exponentially from Interval up to MaxBackoff
+	MaxBackoff time.Duration
+}
+
+func NewMonitorOptions() *MonitorOptions {
+	return &MonitorOptions{
+		Interval:      10 * time.Second,
+		Timeout:       3 * time.Second,
+		RiseThreshold: 2,
+		FallThreshold: 3,
+		MaxBackoff:    2 * time.Minute,
+	}
+}
+
+type BackendStatus struct {
+	Up        bool      `json:"up"`
+	Since     time.Time `json:"since"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+
+	successes int
+	failures  int
+}
+
+/*
+定时检查各个数据库的健康状态。状态切换有滞后: 连续RiseThreshold次成功才标记为up，
+连续FallThreshold次失败才标记为down。InitDB失败(ErrNotInitialized)的数据库会被重新InitDB。
+关闭数据库之前应该先Stop。
+*/
+type Monitor struct {
+	options  *MonitorOptions
+	backends map[string]DB
+
+	mu     sync.RWMutex
+	status map[string]*BackendStatus
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewMonitor(options *MonitorOptions) *Monitor {
+	if options == nil {
+		options = NewMonitorOptions()
+	}
+	return &Monitor{
+		options:  options,
+		backends: make(map[string]DB),
+		status:   make(map[string]*BackendStatus),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Register should be called before Start
+func (m *Monitor) Register(name string, d DB) {
+	m.backends[name] = d
+
+	m.mu.Lock()
+	m.status[name] = &BackendStatus{}
+	m.mu.Unlock()
+}
+
+func (m *Monitor) Start() {
+	for name, d := range m.backends {
+		m.wg.Add(1)
+		go m.watch(name, d)
+	}
+}
+
+func (m *Monitor) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+}
+
+func (m *Monitor) watch(name string, d DB) {
+	defer m.wg.Done()
+
+	first := true
+	wait := m.options.Interval
+	for {
+		err := m.check(d)
+		m.update(name, err, first)
+		first = false
+
+		if err == nil {
+			wait = m.options.Interval
+		} else {
+			wait *= 2
+			if wait > m.options.MaxBackoff {
+				wait = m.options.MaxBackoff
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-m.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (m *Monitor) check(d DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.options.Timeout)
+	defer cancel()
+
+	err := d.Health(ctx)
+	if errors.Is(err, ErrNotInitialized) {
+		if err = d.InitDB(); err == nil {
+			err = d.Health(ctx)
+		}
+	}
+	return err
+}
+
+func (m *Monitor) update(name string, err error, first bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.status[name]
+	now := time.Now()
+	st.LastCheck = now
+
+	if err == nil {
+		st.LastError = ""
+		st.successes++
+		st.failures = 0
+	} else {
+		st.LastError = err.Error()
+		st.failures++
+		st.successes = 0
+	}
+
+	switch {
+	case first:
+		st.Up = err == nil
+		st.Since = now
+	case !st.Up && st.successes >= m.options.RiseThreshold:
+		st.Up = true
+		st.Since = now
+	case st.Up && st.failures >= m.options.FallThreshold:
+		st.Up = false
+		st.Since = now
+	}
+}
+
+// Status returns a snapshot of every registered backend
+func (m *Monitor) Status() map[string]BackendStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ret := make(map[string]BackendStatus, len(m.status))
+	for name, st := range m.status {
+		ret[name] = *st
+	}
+	return ret
+}
+
+// Ready reports whether all backends are up
+func (m *Monitor) Ready() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, st := range m.status {
+		if !st.Up {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP serves a readiness endpoint, 200 if all backends are up otherwise 503. With gin use gin.WrapH(monitor).
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := http.StatusOK
+	if !m.Ready() {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(m.Status())
+}
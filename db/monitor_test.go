@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeDB struct {
+	initialized bool
+	err         error
+}
+
+func (f *fakeDB) InitDB() error {
+	f.initialized = true
+	return nil
+}
+
+func (f *fakeDB) Close() error {
+	return nil
+}
+
+func (f *fakeDB) Health(ctx context.Context) error {
+	if !f.initialized {
+		return ErrNotInitialized
+	}
+	return f.err
+}
+
+func TestMonitorHysteresis(t *testing.T) {
+	d := &fakeDB{}
+	m := NewMonitor(nil)
+	m.Register("fake", d)
+
+	step := func(first bool) bool {
+		m.update("fake", m.check(d), first)
+		return m.Status()["fake"].Up
+	}
+
+	if !step(true) || !d.initialized {
+		t.Fatal("expect backend to be initialized and up")
+	}
+
+	d.err = errors.New("connection refused")
+	if !step(false) || !step(false) {
+		t.Fatal("expect backend to stay up before FallThreshold")
+	}
+	if step(false) || m.Ready() {
+		t.Fatal("expect backend to be down after FallThreshold")
+	}
+
+	d.err = nil
+	if step(false) {
+		t.Fatal("expect backend to stay down before RiseThreshold")
+	}
+	if !step(false) || !m.Ready() {
+		t.Fatal("expect backend to be up after RiseThreshold")
+	}
+}
+
+func TestMonitorServeHTTP(t *testing.T) {
+	m := NewMonitor(nil)
+	m.Register("fake", &fakeDB{})
+	m.update("fake", errors.New("down"), true)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expect 503, got %d", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Querier is satisfied by *sql.DB, *sql.Tx and the SQL backends
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Params holds the values of named parameters such as :id
+type Params map[string]interface{}
+
+/*
+将query中的命名参数(:name)替换为占位符?，返回按顺序排列的参数。
+参数值为slice时展开为多个占位符，方便 IN (:ids) 的写法。引号中的内容不做替换。
+*/
+func Named(query string, params Params) (string, []interface{}, error) {
+	var sb strings.Builder
+	args := make([]interface{}, 0, len(params))
+
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+
+		if quote != 0 {
+			if ch == quote {
+				quote = 0
+			}
+			sb.WriteByte(ch)
+			continue
+		}
+		if ch == '\'' || ch == '"' || ch == '`' {
+			quote = ch
+			sb.WriteByte(ch)
+			continue
+		}
+
+		// "::" is left untouched
+		if ch != ':' || i+1 >= len(query) || !isNameChar(query[i+1]) || (i > 0 && query[i-1] == ':') {
+			sb.WriteByte(ch)
+			continue
+		}
+
+		j := i + 1
+		for j < len(query) && isNameChar(query[j]) {
+			j++
+		}
+		name := query[i+1 : j]
+		value, ok := params[name]
+		if !ok {
+			return "", nil, fmt.Errorf("missing value of named parameter :%s", name)
+		}
+
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			if rv.Len() == 0 {
+				return "", nil, fmt.Errorf("named parameter :%s is an empty slice", name)
+			}
+			for k := 0; k < rv.Len(); k++ {
+				if k > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteByte('?')
+				args = append(args, rv.Index(k).Interface())
+			}
+		} else {
+			sb.WriteByte('?')
+			args = append(args, value)
+		}
+		i = j - 1
+	}
+
+	return sb.String(), args, nil
+}
+
+func isNameChar(ch byte) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9')
+}
+
+// Insert inserts v, a struct or pointer to struct, into table. Fields tagged omitempty are skipped when zero.
+func Insert(ctx context.Context, q Querier, table string, v interface{}) (sql.Result, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("insert value should not be nil")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("insert value should be a struct")
+	}
+
+	columns := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, f := range structFields(rv.Type()) {
+		fv, ok := valueByIndex(rv, f.index)
+		if !ok || (f.omitEmpty && fv.IsZero()) {
+			continue
+		}
+		columns = append(columns, quoteIdent(f.column))
+		args = append(args, fv.Interface())
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("insert value has no columns")
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(table), strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+
+	return q.ExecContext(ctx, query, args...)
+}
+
+// valueByIndex reads a field through embedded pointers, ok is false if one of them is nil
+func valueByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// mysql and sqlite both accept backquoted identifiers
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+type SelectBuilder struct {
+	table   string
+	columns []string
+	where   []string
+	params  Params
+	orderBy []string
+	limit   int
+	offset  int
+}
+
+// Select starts a query on table, all columns are selected if none is given
+func Select(table string, columns ...string) *SelectBuilder {
+	return &SelectBuilder{
+		table:   table,
+		columns: columns,
+		params:  make(Params),
+	}
+}
+
+// Where adds a condition with named parameters, multiple conditions are joined by AND
+func (b *SelectBuilder) Where(cond string, params ...Params) *SelectBuilder {
+	b.where = append(b.where, cond)
+	for _, p := range params {
+		for k, v := range p {
+			b.params[k] = v
+		}
+	}
+	return b
+}
+
+func (b *SelectBuilder) OrderBy(exprs ...string) *SelectBuilder {
+	b.orderBy = append(b.orderBy, exprs...)
+	return b
+}
+
+func (b *SelectBuilder) Limit(n int) *SelectBuilder {
+	b.limit = n
+	return b
+}
+
+func (b *SelectBuilder) Offset(n int) *SelectBuilder {
+	b.offset = n
+	return b
+}
+
+func (b *SelectBuilder) Build() (string, []interface{}, error) {
+	var sb strings.Builder
+
+	sb.WriteString("SELECT ")
+	if len(b.columns) == 0 {
+		sb.WriteString("*")
+	} else {
+		sb.WriteString(strings.Join(b.columns, ", "))
+	}
+	sb.WriteString(" FROM ")
+	sb.WriteString(b.table)
+
+	if len(b.where) > 0 {
+		sb.WriteString(" WHERE (")
+		sb.WriteString(strings.Join(b.where, ") AND ("))
+		sb.WriteString(")")
+	}
+	if len(b.orderBy) > 0 {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(b.orderBy, ", "))
+	}
+	if b.limit > 0 {
+		fmt.Fprintf(&sb, " LIMIT %d", b.limit)
+	}
+	if b.offset > 0 {
+		// both mysql and sqlite require LIMIT before OFFSET
+		if b.limit <= 0 {
+			return "", nil, errors.New("offset without limit is not supported")
+		}
+		fmt.Fprintf(&sb, " OFFSET %d", b.offset)
+	}
+
+	return Named(sb.String(), b.params)
+}
+
+// All runs the query and scans every row into dest, a pointer to a slice of struct
+func (b *SelectBuilder) All(ctx context.Context, q Querier, dest interface{}) error {
+	query, args, err := b.Build()
+	if err != nil {
+		return err
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return ScanAll(rows, dest)
+}
+
+// One runs the query and scans the first row into dest, returns sql.ErrNoRows if there is none
+func (b *SelectBuilder) One(ctx context.Context, q Querier, dest interface{}) error {
+	query, args, err := b.Build()
+	if err != nil {
+		return err
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return ScanOne(rows, dest)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+type testBase struct {
+	ID int64 `db:"id,omitempty"`
+}
+
+type testUser struct {
+	testBase
+	Name    string `json:"name"`
+	Age     int    `db:"age"`
+	Ignored string `db:"-"`
+}
+
+func TestNamed(t *testing.T) {
+	query, args, err := Named("SELECT * FROM t WHERE a = :a AND b IN (:b) AND c = ':c' AND d::text = :d",
+		Params{"a": 1, "b": []int{2, 3}, "d": []byte("x")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expect := "SELECT * FROM t WHERE a = ? AND b IN (?, ?) AND c = ':c' AND d::text = ?"
+	if query != expect {
+		t.Fatalf("expect %s, got %s", expect, query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, 3, []byte("x")}) {
+		t.Fatalf("unexpected args: %v", args)
+	}
+
+	if _, _, err := Named("SELECT :missing", nil); err == nil {
+		t.Fatal("expect error for missing parameter")
+	}
+}
+
+func TestInsertAndSelect(t *testing.T) {
+	d := newTestSqlite(t)
+	ctx := context.Background()
+	if _, err := d.ExecContext(ctx, "ALTER TABLE users ADD COLUMN age INTEGER"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, u := range []*testUser{{Name: "alice", Age: 30}, {Name: "bob", Age: 20}, {Name: "carol", Age: 40}} {
+		if _, err := Insert(ctx, d, "users", u); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	users := make([]*testUser, 0)
+	err := Select("users").Where("age >= :age", Params{"age": 25}).OrderBy("age DESC").Limit(10).All(ctx, d, &users)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(users) != 2 || users[0].Name != "carol" || users[1].Name != "alice" || users[0].ID == 0 {
+		t.Fatalf("unexpected users: %+v %+v", users[0], users[1])
+	}
+
+	var u testUser
+	if err := Select("users", "id", "name").Where("name = :name", Params{"name": "bob"}).One(ctx, d, &u); err != nil {
+		t.Fatal(err.Error())
+	}
+	if u.Name != "bob" || u.Age != 0 {
+		t.Fatalf("unexpected user: %+v", u)
+	}
+
+	err = Select("users").Where("name = :name", Params{"name": "nobody"}).One(ctx, d, &u)
+	if err != sql.ErrNoRows {
+		t.Fatalf("expect sql.ErrNoRows, got %v", err)
+	}
+}
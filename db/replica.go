@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type primaryKey struct{}
+
+// WithPrimary forces reads made with ctx to the primary, e.g. reading right after a write
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func isPrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryKey{}).(bool)
+	return forced
+}
+
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&r.healthy, v)
+}
+
+// replicaSet picks a healthy replica for reads and pings all replicas in background
+type replicaSet struct {
+	policy   string
+	replicas []*replica
+	next     uint32
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReplicaSet(policy string, replicas []*replica) *replicaSet {
+	return &replicaSet{
+		policy:   policy,
+		replicas: replicas,
+		quit:     make(chan struct{}),
+	}
+}
+
+// pick returns nil if no replica is healthy
+func (rs *replicaSet) pick() *sql.DB {
+	n := len(rs.replicas)
+	if n == 0 {
+		return nil
+	}
+
+	if rs.policy == LeastConn {
+		var picked *replica
+		inUse := 0
+		for _, r := range rs.replicas {
+			if !r.isHealthy() {
+				continue
+			}
+			if cur := r.db.Stats().InUse; picked == nil || cur < inUse {
+				picked, inUse = r, cur
+			}
+		}
+		if picked == nil {
+			return nil
+		}
+		return picked.db
+	}
+
+	start := int(atomic.AddUint32(&rs.next, 1))
+	for i := 0; i < n; i++ {
+		if r := rs.replicas[(start+i)%n]; r.isHealthy() {
+			return r.db
+		}
+	}
+	return nil
+}
+
+func (rs *replicaSet) check(timeout time.Duration) {
+	for _, r := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		r.setHealthy(r.db.PingContext(ctx) == nil)
+		cancel()
+	}
+}
+
+func (rs *replicaSet) start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rs.check(interval)
+			case <-rs.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (rs *replicaSet) close() error {
+	close(rs.quit)
+	rs.wg.Wait()
+
+	var err error
+	for _, r := range rs.replicas {
+		if e := r.db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func openTestSqlite(t *testing.T, name string) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE node (name TEXT); INSERT INTO node VALUES (?)", name); err != nil {
+		t.Fatal(err.Error())
+	}
+	return db
+}
+
+func TestReplicaRouting(t *testing.T) {
+	r1 := &replica{addr: "r1", db: openTestSqlite(t, "r1")}
+	r2 := &replica{addr: "r2", db: openTestSqlite(t, "r2")}
+	r1.setHealthy(true)
+	r2.setHealthy(true)
+
+	s := &sqlConn{
+		driver:   "sqlite3",
+		db:       openTestSqlite(t, "primary"),
+		replicas: newReplicaSet(RoundRobin, []*replica{r1, r2}),
+	}
+
+	node := func(ctx context.Context) string {
+		var name string
+		if err := s.QueryRowContext(ctx, "SELECT name FROM node").Scan(&name); err != nil {
+			t.Fatal(err.Error())
+		}
+		return name
+	}
+
+	ctx := context.Background()
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[node(ctx)]++
+	}
+	if seen["r1"] != 2 || seen["r2"] != 2 {
+		t.Fatalf("expect reads spread over replicas, got %v", seen)
+	}
+
+	if name := node(WithPrimary(ctx)); name != "primary" {
+		t.Fatalf("expect primary, got %s", name)
+	}
+
+	r1.setHealthy(false)
+	for i := 0; i < 3; i++ {
+		if name := node(ctx); name != "r2" {
+			t.Fatalf("expect unhealthy r1 to be skipped, got %s", name)
+		}
+	}
+
+	r2.setHealthy(false)
+	if name := node(ctx); name != "primary" {
+		t.Fatalf("expect fallback to primary, got %s", name)
+	}
+}
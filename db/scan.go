@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldInfo maps a column to a (possibly embedded) struct field
+type fieldInfo struct {
+	column    string
+	index     []int
+	omitEmpty bool
+}
+
+var structFieldsCache sync.Map // reflect.Type -> []*fieldInfo
+
+/*
+struct字段对应的列名: 优先使用db标签，其次json标签，都没有时使用字段名。
+标签为"-"的字段会被忽略，匿名嵌入的struct会被展开。
+*/
+func structFields(t reflect.Type) []*fieldInfo {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.([]*fieldInfo)
+	}
+
+	fields := make([]*fieldInfo, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, hasTag := f.Tag.Lookup("db")
+		if !hasTag {
+			tag, hasTag = f.Tag.Lookup("json")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for _, sub := range structFields(ft) {
+				fields = append(fields, &fieldInfo{
+					column:    sub.column,
+					index:     append([]int{i}, sub.index...),
+					omitEmpty: sub.omitEmpty,
+				})
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		fields = append(fields, &fieldInfo{
+			column:    name,
+			index:     []int{i},
+			omitEmpty: hasTag && strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but allocates nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// ScanStruct scans the current row into dest, which must be a pointer to struct.
+// Columns without a matching field are discarded.
+func ScanStruct(rows *sql.Rows, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("scan dest should be a pointer to struct")
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	return scanRow(rows, columns, columnIndex(v.Elem().Type()), v.Elem())
+}
+
+// ScanOne scans the first row into dest and closes rows, returns sql.ErrNoRows if there is none
+func ScanOne(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := ScanStruct(rows, dest); err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+// ScanAll scans all rows into dest and closes rows, dest must be a pointer to []T or []*T where T is a struct
+func ScanAll(rows *sql.Rows, dest interface{}) error {
+	defer rows.Close()
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return errors.New("scan dest should be a pointer to slice")
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return errors.New("scan dest should be a slice of struct")
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	byColumn := columnIndex(elemType)
+	for rows.Next() {
+		elem := reflect.New(elemType)
+		if err := scanRow(rows, columns, byColumn, elem.Elem()); err != nil {
+			return err
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+	}
+
+	return rows.Err()
+}
+
+// columnIndex maps lower-cased column names to field indexes
+func columnIndex(t reflect.Type) map[string][]int {
+	byColumn := make(map[string][]int)
+	for _, f := range structFields(t) {
+		byColumn[strings.ToLower(f.column)] = f.index
+	}
+	return byColumn
+}
+
+func scanRow(rows *sql.Rows, columns []string, byColumn map[string][]int, v reflect.Value) error {
+	targets := make([]interface{}, len(columns))
+	for i, col := range columns {
+		index, ok := byColumn[strings.ToLower(col)]
+		if !ok {
+			targets[i] = new(interface{})
+			continue
+		}
+		targets[i] = fieldByIndex(v, index).Addr().Interface()
+	}
+
+	return rows.Scan(targets...)
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	mysqldrv "github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// TxBeginner is satisfied by the SQL backends as well as *sql.DB
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+
+	// retry on deadlock / lock wait timeout / SQLITE_BUSY, 0 means no retry
+	MaxRetries int
+	// initial backoff, doubled on every retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+func NewTxOptions() *TxOptions {
+	return &TxOptions{
+		Isolation:  sql.LevelDefault,
+		MaxRetries: 3,
+		Backoff:    20 * time.Millisecond,
+		MaxBackoff: time.Second,
+	}
+}
+
+// Tx is a *sql.Tx whose statements are counted by the QueryMetrics of the backend it was begun on
+type Tx struct {
+	*sql.Tx
+	metrics *QueryMetrics
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	ret, err := tx.Tx.ExecContext(ctx, query, args...)
+	tx.metrics.observe(&QueryEvent{Op: OpExec, Query: query, Args: args, Elapsed: time.Since(start), Err: err})
+
+	return ret, err
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	tx.metrics.observe(&QueryEvent{Op: OpQuery, Query: query, Args: args, Elapsed: time.Since(start), Err: err})
+
+	return rows, err
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	tx.metrics.observe(&QueryEvent{Op: OpQuery, Query: query, Args: args, Elapsed: time.Since(start), Err: row.Err()})
+
+	return row
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
+
+/*
+在事务中执行fn，fn返回nil时提交，返回错误或panic时回滚。
+遇到死锁、锁等待超时、SQLITE_BUSY时按退避策略重试整个fn，因此fn应该可以安全地重复执行。
+db是SQL backend时，tx上执行的语句计入它的QueryMetrics。
+*/
+func WithTx(ctx context.Context, db TxBeginner, opts *TxOptions, fn func(tx *Tx) error) error {
+	if opts == nil {
+		opts = NewTxOptions()
+	}
+	backoff := opts.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || attempt >= opts.MaxRetries || !IsRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(jitter(backoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+func runTx(ctx context.Context, db TxBeginner, opts *TxOptions, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return err
+	}
+
+	tx := &Tx{Tx: sqlTx}
+	if m, ok := db.(interface{ QueryMetrics() *QueryMetrics }); ok {
+		tx.metrics = m.QueryMetrics()
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+			return fmt.Errorf("%w (rollback failed: %s)", err, rbErr.Error())
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsRetryable reports whether err is a transient locking error worth retrying the transaction for
+func IsRetryable(err error) bool {
+	var myErr *mysqldrv.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == mysqlErrDeadlock || myErr.Number == mysqlErrLockWaitTimeout
+	}
+
+	var liteErr sqlite3.Error
+	if errors.As(err, &liteErr) {
+		return liteErr.Code == sqlite3.ErrBusy
+	}
+
+	return false
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+}
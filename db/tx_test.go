@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	mysqldrv "github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+func newTestSqlite(t *testing.T) SQLDB {
+	config := NewConfig()
+	config.Name = filepath.Join(t.TempDir(), "test.db")
+
+	d := NewDBWithConfig("sqlite", config).(SQLDB)
+	if err := d.InitDB(); err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	if _, err := d.SqlDB().Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err.Error())
+	}
+	return d
+}
+
+func countUsers(t *testing.T, d SQLDB) int {
+	var n int
+	if err := d.SqlDB().QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		t.Fatal(err.Error())
+	}
+	return n
+}
+
+func TestWithTx(t *testing.T) {
+	d := newTestSqlite(t)
+	ctx := context.Background()
+
+	err := WithTx(ctx, d, nil, func(tx *Tx) error {
+		_, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "alice")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	errFail := errors.New("fail")
+	err = WithTx(ctx, d, nil, func(tx *Tx) error {
+		if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "bob"); err != nil {
+			return err
+		}
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("expect %v, got %v", errFail, err)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal("expect panic to be re-raised")
+			}
+		}()
+		_ = WithTx(ctx, d, nil, func(tx *Tx) error {
+			if _, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "carol"); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	if n := countUsers(t, d); n != 1 {
+		t.Fatalf("expect 1 user, got %d", n)
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	d := newTestSqlite(t)
+	opts := NewTxOptions()
+	opts.Backoff = 0
+
+	calls := 0
+	err := WithTx(context.Background(), d, opts, func(tx *Tx) error {
+		calls++
+		if calls < 3 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		_, err := tx.Exec("INSERT INTO users (name) VALUES (?)", "dave")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if calls != 3 {
+		t.Fatalf("expect 3 calls, got %d", calls)
+	}
+	if n := countUsers(t, d); n != 1 {
+		t.Fatalf("expect 1 user, got %d", n)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[error]bool{
+		&mysqldrv.MySQLError{Number: 1213}:                            true,
+		fmt.Errorf("wrapped: %w", &mysqldrv.MySQLError{Number: 1205}): true,
+		&mysqldrv.MySQLError{Number: 1062}:                            false,
+		sqlite3.Error{Code: sqlite3.ErrBusy}:                          true,
+		sqlite3.Error{Code: sqlite3.ErrConstraint}:                    false,
+		errors.New("other"):                                           false,
+	}
+	for err, expect := range cases {
+		if got := IsRetryable(err); got != expect {
+			t.Errorf("IsRetryable(%v) = %v, expect %v", err, got, expect)
+		}
+	}
+}
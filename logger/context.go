@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying id, the *Ctx functions log it as request_id
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func DebugCtx(ctx context.Context, brief string, detail string, mps ...map[string]interface{}) {
+	fields := ctxFields(ctx, baseFields(detail), mps)
+	zapInfoLogger.Debug(brief, fields...)
+}
+
+func InfoCtx(ctx context.Context, brief string, detail string, mps ...map[string]interface{}) {
+	fields := ctxFields(ctx, baseFields(detail), mps)
+	zapInfoLogger.Info(brief, fields...)
+}
+
+func WarnCtx(ctx context.Context, brief string, detail string, mps ...map[string]interface{}) {
+	fields := ctxFields(ctx, baseFields(detail), mps)
+	zapInfoLogger.Warn(brief, fields...)
+}
+
+func ErrorCtx(ctx context.Context, brief string, detail string, mps ...map[string]interface{}) {
+	fields := ctxFields(ctx, baseFields(detail), mps)
+	zapLogger.Error(brief, fields...)
+}
+
+func ctxFields(ctx context.Context, fields []zap.Field, mps []map[string]interface{}) []zap.Field {
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	for _, mp := range mps {
+		for k, v := range mp {
+			fields = appendFields(fields, k, v)
+		}
+	}
+	return fields
+}
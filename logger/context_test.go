@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestInfoCtx(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	old := zapInfoLogger
+	zapInfoLogger = zap.New(core)
+	defer func() { zapInfoLogger = old }()
+
+	ctx := ContextWithRequestID(context.Background(), "req-1")
+	InfoCtx(ctx, "order created", "", map[string]interface{}{"id": 7})
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expect 1 entry, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["request_id"] != "req-1" || fields["id"] != int64(7) || fields["file"] != "context_test.go" {
+		t.Fatalf("unexpected fields %v", fields)
+	}
+}
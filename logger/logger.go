@@ -11,9 +11,10 @@ import (
 	"strings"
 )
 
+// discard logs until InitLogger is called
 var (
-	zapLogger *zap.Logger
-	zapInfoLogger *zap.Logger
+	zapLogger = zap.NewNop()
+	zapInfoLogger = zap.NewNop()
 )
 
 type Options struct {
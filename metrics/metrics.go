@@ -0,0 +1,344 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry used by the package level functions
+var Default = NewRegistry()
+
+/*
+指标注册表，输出Prometheus文本格式。
+同名指标重复注册时返回已注册的指标，类型或标签不一致时panic。
+*/
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]collector)}
+}
+
+type collector interface {
+	describe() *desc
+	write(w io.Writer)
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (r *Registry) register(d *desc, create func() collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.metrics[d.name]; ok {
+		old := c.describe()
+		if old.typ != d.typ || strings.Join(old.labels, ",") != strings.Join(d.labels, ",") {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %s with labels %v", d.name, old.typ, old.labels))
+		}
+		return c
+	}
+	c := create()
+	r.metrics[d.name] = c
+	return c
+}
+
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	d := &desc{name: name, help: help, typ: "counter", labels: labels}
+	return r.register(d, func() collector {
+		return &Counter{vec: newVec(d)}
+	}).(*Counter)
+}
+
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	d := &desc{name: name, help: help, typ: "gauge", labels: labels}
+	return r.register(d, func() collector {
+		return &Gauge{vec: newVec(d)}
+	}).(*Gauge)
+}
+
+// NewHistogram uses DefBuckets if buckets is empty
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	d := &desc{name: name, help: help, typ: "histogram", labels: labels}
+	return r.register(d, func() collector {
+		return &Histogram{vec: newVec(d), buckets: buckets}
+	}).(*Histogram)
+}
+
+// WriteText writes every metric in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	collectors := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, r.metrics[name])
+	}
+	r.mu.RUnlock()
+
+	ew := &errWriter{w: w}
+	for _, c := range collectors {
+		d := c.describe()
+		fmt.Fprintf(ew, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+		fmt.Fprintf(ew, "# TYPE %s %s\n", d.name, d.typ)
+		c.write(ew)
+	}
+	return ew.err
+}
+
+// ServeHTTP serves the metrics, e.g. mux.Handle("/metrics", metrics.Default)
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// vec holds the series of a metric, keyed by the label values
+type vec struct {
+	*desc
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+	// histogram only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newVec(d *desc) *vec {
+	return &vec{desc: d, series: make(map[string]*series)}
+}
+
+func (v *vec) describe() *desc {
+	return v.desc
+}
+
+// get must be called with v.mu held
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) sorted() []*series {
+	ret := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return strings.Join(ret[i].labels, "\xff") < strings.Join(ret[j].labels, "\xff")
+	})
+	return ret
+}
+
+func (v *vec) writeValues(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, s.labels, "", ""), formatValue(s.value))
+	}
+}
+
+type Counter struct {
+	*vec
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add panics if delta is negative, counters only go up
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.name + " can't decrease")
+	}
+	c.mu.Lock()
+	c.get(values).value += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeValues(w)
+}
+
+type Gauge struct {
+	*vec
+}
+
+func (g *Gauge) Set(value float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value = value
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.writeValues(w)
+}
+
+type Histogram struct {
+	*vec
+	buckets []float64
+}
+
+func (h *Histogram) Observe(value float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	// counts are per bucket, made cumulative when written
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+// errWriter keeps the first write error so that the callers don't check every write
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.err = err
+	return n, err
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	jobs := r.NewCounter("cron_jobs_total", "Jobs run.", "job")
+	conns := r.NewGauge("db_open_connections", "Open connections.")
+	latency := r.NewHistogram("kafka_send_seconds", "Send latency.", []float64{0.1, 0.5}, "topic")
+
+	jobs.Inc("clean")
+	jobs.Add(2, "sync\"er")
+	conns.Set(3)
+	conns.Dec()
+	latency.Observe(0.05, "orders")
+	latency.Observe(0.3, "orders")
+	latency.Observe(2, "orders")
+
+	if r.NewCounter("cron_jobs_total", "Jobs run.", "job") != jobs {
+		t.Fatal("expect the registered counter returned")
+	}
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err.Error())
+	}
+	expect := `# HELP cron_jobs_total Jobs run.
+# TYPE cron_jobs_total counter
+cron_jobs_total{job="clean"} 1
+cron_jobs_total{job="sync\"er"} 2
+# HELP db_open_connections Open connections.
+# TYPE db_open_connections gauge
+db_open_connections 2
+# HELP kafka_send_seconds Send latency.
+# TYPE kafka_send_seconds histogram
+kafka_send_seconds_bucket{topic="orders",le="0.1"} 1
+kafka_send_seconds_bucket{topic="orders",le="0.5"} 2
+kafka_send_seconds_bucket{topic="orders",le="+Inf"} 3
+kafka_send_seconds_sum{topic="orders"} 2.35
+kafka_send_seconds_count{topic="orders"} 3
+`
+	if b.String() != expect {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("jobs", "", "job")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic on conflicting registration")
+		}
+	}()
+	r.NewGauge("jobs", "", "job")
+}
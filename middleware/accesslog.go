@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+type AccessLogConfig struct {
+	// paths not logged, e.g. health checks, exact match or prefix ending with /*
+	SkipPaths []string
+	// fraction of the successful requests logged, 4xx and 5xx are always logged
+	SampleRate float64
+	UserHeader string
+}
+
+func NewAccessLogConfig() *AccessLogConfig {
+	return &AccessLogConfig{
+		SampleRate: 1,
+		UserHeader: "x-forward-user",
+	}
+}
+
+/*
+访问日志，通过logger写入JSON日志，替代gin默认输出到stdout的文本日志。
+状态码小于400的请求使用logger.Info，其余使用logger.Warn。
+*/
+func AccessLog(config *AccessLogConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest && config.SampleRate < 1 && rand.Float64() >= config.SampleRate {
+			return
+		}
+
+		user := c.GetHeader(config.UserHeader)
+		if identity, ok := GetIdentity(c); ok && identity.User != "" {
+			user = identity.User
+		}
+		bytesIn := c.Request.ContentLength
+		if bytesIn < 0 {
+			bytesIn = 0
+		}
+		bytesOut := c.Writer.Size()
+		if bytesOut < 0 {
+			bytesOut = 0
+		}
+
+		fields := map[string]interface{}{
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"route":      routeTemplate(c),
+			"status":     status,
+			"latency_ms": float64(latency) / float64(time.Millisecond),
+			"bytes_in":   bytesIn,
+			"bytes_out":  bytesOut,
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+			"user":       user,
+			"request_id": requestID(c),
+		}
+		detail := c.Errors.String()
+
+		if status < http.StatusBadRequest {
+			logger.Info("access", detail, fields)
+		} else {
+			logger.Warn("access", detail, fields)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	var got string
+	r := gin.New()
+	r.Use(RouteTemplate(r))
+	handler := func(c *gin.Context) { got = routeTemplate(c) }
+	r.GET("/orders/:id", handler)
+	r.GET("/users/:user/orders/:id", handler)
+	r.GET("/static/*filepath", handler)
+	r.GET("/health", handler)
+
+	cases := map[string]string{
+		"/orders/42":             "/orders/:id",
+		"/orders/orders":         "/orders/:id",
+		"/users/7/orders/7":      "/users/:user/orders/:id",
+		"/users/7/orders/orders": "/users/:user/orders/:id",
+		"/users/orders/orders/1": "/users/:user/orders/:id",
+		"/static/css/app.css":    "/static/*filepath",
+		"/static/static/orders":  "/static/*filepath",
+		"/health":                "/health",
+	}
+	for path, expect := range cases {
+		doRequest(r, http.MethodGet, path, nil)
+		if got != expect {
+			t.Errorf("%s: expect %s, got %s", path, expect, got)
+		}
+	}
+}
+
+func TestRouteTemplateNotInstalled(t *testing.T) {
+	config := NewTimeoutConfig(0)
+	config.Routes["GET /orders/:id"] = time.Nanosecond
+	config.Routes["GET /health"] = time.Nanosecond
+
+	var route string
+	r := gin.New()
+	r.Use(Timeout(config))
+	handler := func(c *gin.Context) {
+		route = routeTemplate(c)
+		<-c.Request.Context().Done()
+		// give the timeout response time to be written
+		time.Sleep(10 * time.Millisecond)
+		c.Status(http.StatusOK)
+	}
+	r.GET("/orders/:id", func(c *gin.Context) {
+		route = routeTemplate(c)
+		c.Status(http.StatusOK)
+	})
+	r.GET("/health", handler)
+
+	// without RouteTemplate the template of a path with parameters is unknown, its per-route config is skipped
+	if w := doRequest(r, http.MethodGet, "/orders/orders", nil); w.Code != http.StatusOK || route != "" {
+		t.Fatalf("expect the default timeout, got %d route %q", w.Code, route)
+	}
+	// a path without parameters is its own template
+	if w := doRequest(r, http.MethodGet, "/health", nil); w.Code != http.StatusGatewayTimeout || route != "/health" {
+		t.Fatalf("expect the route timeout, got %d route %q", w.Code, route)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	lc := &logger.Options{ErrLog: path, InfoLog: path, MaxSize: 1, Level: "debug"}
+	if err := lc.InitLogger(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	config := NewAccessLogConfig()
+	config.SkipPaths = []string{"/health"}
+	config.SampleRate = 0
+
+	r := gin.New()
+	r.Use(RouteTemplate(r), AccessLog(config))
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/orders/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.POST("/orders/:id", func(c *gin.Context) { c.String(http.StatusBadRequest, "bad") })
+
+	doRequest(r, http.MethodGet, "/health", nil)
+	// sampled out
+	doRequest(r, http.MethodGet, "/orders/1", nil)
+	doRequest(r, http.MethodPost, "/orders/2", map[string]string{"x-forward-user": "alice"})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expect 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e["level"] != "WARN" || e["route"] != "/orders/:id" || e["status"] != float64(400) || e["user"] != "alice" || e["bytes_out"] != float64(3) {
+		t.Fatalf("unexpected entry %v", e)
+	}
+}
@@ -1,14 +1,125 @@
 package middleware
 
-import "github.com/gin-gonic/gin"
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
 
-func AuthToken(c *gin.Context) {
-	name := c.GetHeader("x-forward-user")
-	token := c.GetHeader("authentication")
+	"github.com/gin-gonic/gin"
 
-	if name == "" || token == "" {
+	"basego/logger"
+)
 
+const IdentityKey = "basego.identity"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Identity is put into the gin context by the auth middlewares
+type Identity struct {
+	User  string
+	Roles []string
+	Extra map[string]interface{}
+}
+
+// TokenVerifier checks the token presented by user and returns the verified identity
+type TokenVerifier interface {
+	Verify(ctx context.Context, user string, token string) (*Identity, error)
+}
+
+type TokenVerifierFunc func(ctx context.Context, user string, token string) (*Identity, error)
+
+func (f TokenVerifierFunc) Verify(ctx context.Context, user string, token string) (*Identity, error) {
+	return f(ctx, user, token)
+}
+
+// StaticTokenVerifier maps users to fixed tokens
+type StaticTokenVerifier map[string]string
+
+func (s StaticTokenVerifier) Verify(ctx context.Context, user string, token string) (*Identity, error) {
+	expect, ok := s[user]
+	if !ok || subtle.ConstantTimeCompare([]byte(expect), []byte(token)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	return &Identity{User: user}, nil
+}
+
+type AuthConfig struct {
+	Verifier    TokenVerifier
+	UserHeader  string
+	TokenHeader string
+	// public paths, exact match or prefix ending with /*
+	SkipPaths []string
+}
+
+func NewAuthConfig(verifier TokenVerifier) *AuthConfig {
+	return &AuthConfig{
+		Verifier:    verifier,
+		UserHeader:  "x-forward-user",
+		TokenHeader: "authentication",
 	}
+}
+
+// AuthToken verifies the user and token headers, requests failing verification are rejected with 401
+func AuthToken(config *AuthConfig) gin.HandlerFunc {
+	if config.Verifier == nil {
+		panic("middleware: AuthToken needs a TokenVerifier")
+	}
+
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
 
-	c.Next()
+		name := c.GetHeader(config.UserHeader)
+		token := c.GetHeader(config.TokenHeader)
+		if name == "" || token == "" {
+			abortUnauthorized(c, "missing user or token")
+			return
+		}
+
+		// the cause is only logged, callers always get the same message
+		identity, err := config.Verifier.Verify(c.Request.Context(), name, token)
+		if err == nil && identity == nil {
+			err = errors.New("verifier returned no identity")
+		}
+		if err != nil {
+			logger.Warn("verify token failed", err.Error(), map[string]interface{}{"user": name, "request_id": requestID(c)})
+			abortUnauthorized(c, ErrInvalidToken.Error())
+			return
+		}
+
+		c.Set(IdentityKey, identity)
+		c.Next()
+	}
+}
+
+// GetIdentity returns the identity set by an auth middleware
+func GetIdentity(c *gin.Context) (*Identity, bool) {
+	v, ok := c.Get(IdentityKey)
+	if !ok {
+		return nil, false
+	}
+	identity, ok := v.(*Identity)
+	return identity, ok && identity != nil
+}
+
+func abortUnauthorized(c *gin.Context, message string) {
+	Fail(c, ErrUnauthorized.WithMessage(message))
+}
+
+// matchPath reports whether path equals one of patterns, or has the prefix of a pattern ending with /*
+func matchPath(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if strings.HasSuffix(p, "/*") {
+			prefix := strings.TrimSuffix(p, "*")
+			if strings.HasPrefix(path, prefix) || path == strings.TrimSuffix(prefix, "/") {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func doRequest(r http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthToken(t *testing.T) {
+	config := NewAuthConfig(StaticTokenVerifier{"alice": "secret"})
+	config.SkipPaths = []string{"/health", "/public/*"}
+
+	r := gin.New()
+	r.Use(AuthToken(config))
+	handler := func(c *gin.Context) {
+		user := ""
+		if identity, ok := GetIdentity(c); ok {
+			user = identity.User
+		}
+		c.String(http.StatusOK, user)
+	}
+	r.GET("/orders", handler)
+	r.GET("/health", handler)
+	r.GET("/public/docs", handler)
+
+	cases := []struct {
+		path    string
+		headers map[string]string
+		code    int
+		body    string
+	}{
+		{"/orders", nil, http.StatusUnauthorized, ""},
+		{"/orders", map[string]string{"x-forward-user": "alice", "authentication": "wrong"}, http.StatusUnauthorized, ""},
+		{"/orders", map[string]string{"x-forward-user": "alice", "authentication": "secret"}, http.StatusOK, "alice"},
+		{"/health", nil, http.StatusOK, ""},
+		{"/public/docs", nil, http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		w := doRequest(r, http.MethodGet, c.path, c.headers)
+		if w.Code != c.code {
+			t.Errorf("%s: expect %d, got %d", c.path, c.code, w.Code)
+		}
+		if c.code == http.StatusOK && w.Body.String() != c.body {
+			t.Errorf("%s: expect body %q, got %q", c.path, c.body, w.Body.String())
+		}
+	}
+}
+
+func TestAuthTokenVerifyFailure(t *testing.T) {
+	verifier := TokenVerifierFunc(func(ctx context.Context, user string, token string) (*Identity, error) {
+		if user == "alice" {
+			return nil, errors.New("dial tcp 10.0.0.1:389: connection refused")
+		}
+		return nil, nil
+	})
+
+	r := gin.New()
+	r.Use(RequestID(), AuthToken(NewAuthConfig(verifier)))
+	r.GET("/orders", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, user := range []string{"alice", "bob"} {
+		w := doRequest(r, http.MethodGet, "/orders", map[string]string{"x-forward-user": user, "authentication": "token"})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expect 401, got %d", user, w.Code)
+		}
+		var resp Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err.Error())
+		}
+		if resp.Message != "invalid token" || resp.RequestID == "" {
+			t.Fatalf("%s: unexpected response %+v", user, resp)
+		}
+	}
+}
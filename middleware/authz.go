@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+角色到权限的映射，权限形如"orders:write"，
+支持"orders:*"匹配orders下的所有权限，"*"匹配所有权限。
+*/
+type Policy struct {
+	Roles map[string][]string `json:"roles" yaml:"roles"`
+}
+
+func NewPolicy(roles map[string][]string) *Policy {
+	return &Policy{Roles: roles}
+}
+
+// Allowed reports whether any of roles grants perm
+func (p *Policy) Allowed(roles []string, perm string) bool {
+	for _, role := range roles {
+		for _, granted := range p.Roles[role] {
+			if grants(granted, perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func grants(granted string, perm string) bool {
+	if granted == "*" || granted == perm {
+		return true
+	}
+	return strings.HasSuffix(granted, ":*") && strings.HasPrefix(perm, granted[:len(granted)-1])
+}
+
+type PolicyLoader interface {
+	Load() (*Policy, error)
+}
+
+type PolicyLoaderFunc func() (*Policy, error)
+
+func (f PolicyLoaderFunc) Load() (*Policy, error) {
+	return f()
+}
+
+// PolicyFile loads the policy from a json or yaml file, chosen by the extension
+type PolicyFile string
+
+func (f PolicyFile) Load() (*Policy, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	switch strings.ToLower(filepath.Ext(string(f))) {
+	case ".json":
+		err = json.Unmarshal(data, p)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, p)
+	default:
+		return nil, fmt.Errorf("unsupported policy file %s", string(f))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Roles) == 0 {
+		return nil, errors.New("policy has no roles")
+	}
+	return p, nil
+}
+
+/*
+Authorizer根据AuthToken/JWT设置的Identity中的角色判断权限，
+没有Identity返回401，权限不足返回403。
+Reload重新加载策略，失败时继续使用旧的策略。
+*/
+type Authorizer struct {
+	loader PolicyLoader
+
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+func NewAuthorizer(loader PolicyLoader) (*Authorizer, error) {
+	a := &Authorizer{loader: loader}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Authorizer) Reload() error {
+	a.mu.RLock()
+	loader := a.loader
+	a.mu.RUnlock()
+
+	if loader == nil {
+		return errors.New("no policy loader")
+	}
+	p, err := loader.Load()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.policy = p
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Authorizer) Policy() *Policy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.policy
+}
+
+// Require allows requests whose identity has every one of perms
+func (a *Authorizer) Require(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := GetIdentity(c)
+		if !ok {
+			abortUnauthorized(c, "missing identity")
+			return
+		}
+
+		p := a.Policy()
+		for _, perm := range perms {
+			if !p.Allowed(identity.Roles, perm) {
+				Fail(c, ErrForbidden.WithMessage("permission denied: "+perm))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+var defaultAuthorizer = &Authorizer{policy: NewPolicy(nil)}
+
+// SetPolicyLoader loads the policy used by Require, the loader is kept for ReloadPolicy
+func SetPolicyLoader(loader PolicyLoader) error {
+	p, err := loader.Load()
+	if err != nil {
+		return err
+	}
+
+	defaultAuthorizer.mu.Lock()
+	defaultAuthorizer.loader = loader
+	defaultAuthorizer.policy = p
+	defaultAuthorizer.mu.Unlock()
+	return nil
+}
+
+func ReloadPolicy() error {
+	return defaultAuthorizer.Reload()
+}
+
+// Require checks perms against the policy of SetPolicyLoader, every permission is denied before it's called
+func Require(perms ...string) gin.HandlerFunc {
+	return defaultAuthorizer.Require(perms...)
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	p := NewPolicy(map[string][]string{
+		"admin":  {"*"},
+		"clerk":  {"orders:*", "users:read"},
+		"viewer": {"orders:read"},
+	})
+
+	cases := []struct {
+		roles  []string
+		perm   string
+		expect bool
+	}{
+		{[]string{"admin"}, "users:delete", true},
+		{[]string{"clerk"}, "orders:write", true},
+		{[]string{"clerk"}, "users:write", false},
+		{[]string{"viewer"}, "orders:write", false},
+		{[]string{"viewer", "clerk"}, "users:read", true},
+		{[]string{"clerk"}, "ordersx:read", false},
+		{nil, "orders:read", false},
+	}
+	for _, c := range cases {
+		if p.Allowed(c.roles, c.perm) != c.expect {
+			t.Errorf("%v %s: expect %v", c.roles, c.perm, c.expect)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	policy := "roles:\n  clerk: [\"orders:read\"]\n"
+	if err := ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := SetPolicyLoader(PolicyFile(path)); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer func() { defaultAuthorizer = &Authorizer{policy: NewPolicy(nil)} }()
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if roles := c.GetHeader("x-roles"); roles != "" {
+			c.Set(IdentityKey, &Identity{User: "alice", Roles: strings.Split(roles, ",")})
+		}
+	})
+	r.GET("/orders", Require("orders:read"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/orders", Require("orders:read", "orders:write"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	clerk := map[string]string{"x-roles": "clerk"}
+	if w := doRequest(r, http.MethodGet, "/orders", clerk); w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+	if w := doRequest(r, http.MethodGet, "/orders", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect 401 without identity, got %d", w.Code)
+	}
+	w := doRequest(r, http.MethodPost, "/orders", clerk)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "orders:write") {
+		t.Fatalf("expect 403, got %d %s", w.Code, w.Body.String())
+	}
+
+	policy = "roles:\n  clerk: [\"orders:*\"]\n"
+	if err := ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := ReloadPolicy(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if w := doRequest(r, http.MethodPost, "/orders", clerk); w.Code != http.StatusOK {
+		t.Fatalf("expect 200 after reload, got %d", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BodyLimitConfig struct {
+	// max bytes of the (decompressed) request body, 0 for no limit
+	Limit int64
+	// limits of specific routes, keyed by method and route template, e.g. "POST /files",
+	// routes with parameters are only matched when RouteTemplate is installed
+	Routes map[string]int64
+	// decompress request bodies with Content-Encoding: gzip
+	Decompress bool
+}
+
+func NewBodyLimitConfig(limit int64) *BodyLimitConfig {
+	return &BodyLimitConfig{
+		Limit:      limit,
+		Routes:     make(map[string]int64),
+		Decompress: true,
+	}
+}
+
+/*
+限制请求体大小，Content-Length超过限制时直接返回413，
+否则读取超过限制时Read返回ErrPayloadTooLarge，handler通过Fail(c, err)返回413。
+gzip压缩的请求体会被透明解压，限制作用于解压后的大小。
+*/
+func BodyLimit(config *BodyLimitConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := config.Limit
+		if len(config.Routes) > 0 {
+			if route, ok := routeKey(c); ok {
+				if l, ok := config.Routes[route]; ok {
+					limit = l
+				}
+			}
+		}
+		if c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		decompress := config.Decompress && strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip")
+		// the compressed length says nothing about the decompressed size
+		if !decompress && limit > 0 && c.Request.ContentLength > limit {
+			Fail(c, ErrPayloadTooLarge)
+			return
+		}
+
+		body := c.Request.Body
+		var reader io.Reader = body
+		if decompress {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				Fail(c, ErrBadRequest.WithMessage("invalid gzip body").Wrap(err))
+				return
+			}
+			defer gz.Close()
+			reader = gz
+
+			c.Request.Header.Del("Content-Encoding")
+			c.Request.Header.Del("Content-Length")
+			c.Request.ContentLength = -1
+		}
+		if limit > 0 {
+			reader = &limitedReader{r: reader, remaining: limit}
+		}
+		if reader != io.Reader(body) {
+			c.Request.Body = &readCloser{Reader: reader, Closer: body}
+		}
+		c.Next()
+	}
+}
+
+// limitedReader fails with ErrPayloadTooLarge instead of returning EOF like io.LimitReader
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrPayloadTooLarge
+	}
+	// read one byte more to tell a body of exactly the limit from a larger one
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), ErrPayloadTooLarge
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestBodyLimit(t *testing.T) {
+	config := NewBodyLimitConfig(10)
+	config.Routes["POST /files"] = 100
+
+	r := gin.New()
+	r.Use(BodyLimit(config))
+	handler := func(c *gin.Context) {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			Fail(c, err)
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	}
+	r.POST("/orders", handler)
+	r.POST("/files", handler)
+
+	cases := []struct {
+		path     string
+		body     []byte
+		encoding string
+		chunked  bool
+		code     int
+		expect   string
+	}{
+		{"/orders", []byte("0123456789"), "", false, http.StatusOK, "0123456789"},
+		{"/orders", []byte("0123456789a"), "", false, http.StatusRequestEntityTooLarge, ""},
+		{"/orders", []byte("0123456789a"), "", true, http.StatusRequestEntityTooLarge, ""},
+		{"/files", bytes.Repeat([]byte("a"), 50), "", false, http.StatusOK, strings.Repeat("a", 50)},
+		{"/orders", gzipBytes([]byte("hello")), "gzip", false, http.StatusOK, "hello"},
+		// small on the wire, over the limit once decompressed
+		{"/files", gzipBytes(bytes.Repeat([]byte("a"), 1000)), "gzip", false, http.StatusRequestEntityTooLarge, ""},
+		{"/orders", []byte("plain"), "gzip", false, http.StatusBadRequest, ""},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, bytes.NewReader(c.body))
+		if c.encoding != "" {
+			req.Header.Set("Content-Encoding", c.encoding)
+		}
+		if c.chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != c.code || (c.code == http.StatusOK && w.Body.String() != c.expect) {
+			t.Errorf("case %d: expect %d %s, got %d %s", i, c.code, c.expect, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestBodyLimitBind(t *testing.T) {
+	r := gin.New()
+	r.Use(Format(), BodyLimit(NewBodyLimitConfig(16)))
+	r.POST("/bind", func(c *gin.Context) {
+		var body map[string]string
+		if c.BindJSON(&body) == nil {
+			OK(c, body)
+		}
+	})
+	r.POST("/should-bind", func(c *gin.Context) {
+		var body map[string]string
+		if err := c.ShouldBindJSON(&body); err != nil {
+			Fail(c, err)
+			return
+		}
+		OK(c, body)
+	})
+
+	cases := []struct {
+		body   string
+		status int
+		code   int
+	}{
+		{`{"a":"b"}`, http.StatusOK, CodeOK},
+		{`{"a":"0123456789abcdef"}`, http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge},
+		{`{"a"}`, http.StatusBadRequest, http.StatusBadRequest},
+	}
+	for _, path := range []string{"/bind", "/should-bind"} {
+		for _, c := range cases {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(c.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.ContentLength = -1
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != c.status || !strings.Contains(w.Body.String(), `"code":`+strconv.Itoa(c.code)) {
+				t.Errorf("%s %s: expect %d, got %d %s", path, c.body, c.status, w.Code, w.Body.String())
+			}
+		}
+	}
+}
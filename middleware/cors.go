@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+AllowOrigins支持完整匹配(https://a.com)、子域名通配(https://*.example.com)以及"*"，
+AllowOriginRegexps为正则匹配，需要匹配整个Origin。匹配的Origin会被原样返回，
+而不是返回"*"，所以除了"*"以外都可以和AllowCredentials同时使用。
+*/
+type CORSConfig struct {
+	AllowOrigins       []string
+	AllowOriginRegexps []string
+	AllowMethods       []string
+	// "*" allows every requested header
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func NewCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		AllowMethods:  []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders:  []string{"Origin", "Content-Type", "Content-Length", "Authorization", "X-Request-ID"},
+		ExposeHeaders: []string{"Content-Length", "X-Request-ID"},
+		MaxAge:        12 * time.Hour,
+	}
+}
+
+type corsPolicy struct {
+	anyOrig  bool
+	exact    map[string]bool
+	wildcard [][2]string
+	regexps  []*regexp.Regexp
+	anyHdr   bool
+	headers  map[string]bool
+	methods  map[string]bool
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// CORS panics on an invalid AllowOriginRegexps, or on "*" in AllowOrigins together with AllowCredentials
+func CORS(config *CORSConfig) gin.HandlerFunc {
+	p := newCORSPolicy(config)
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !p.allowOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		if config.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if p.exposeHeaders != "" {
+				c.Header("Access-Control-Expose-Headers", p.exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !p.methods[strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))] {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		requested := c.GetHeader("Access-Control-Request-Headers")
+		if !p.allowRequestHeaders(requested) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Header("Access-Control-Allow-Methods", p.allowMethods)
+		if p.anyHdr {
+			if requested != "" {
+				c.Header("Access-Control-Allow-Headers", requested)
+			}
+		} else if p.allowHeaders != "" {
+			c.Header("Access-Control-Allow-Headers", p.allowHeaders)
+		}
+		if p.maxAge != "" {
+			c.Header("Access-Control-Max-Age", p.maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func newCORSPolicy(config *CORSConfig) *corsPolicy {
+	p := &corsPolicy{
+		exact:   make(map[string]bool),
+		headers: make(map[string]bool),
+		methods: make(map[string]bool),
+	}
+
+	for _, o := range config.AllowOrigins {
+		switch {
+		case o == "*":
+			// echoing every origin with credentials would let any site read authenticated responses
+			if config.AllowCredentials {
+				panic(`middleware: CORS can't allow origin "*" with credentials`)
+			}
+			p.anyOrig = true
+		case strings.Contains(o, "*"):
+			idx := strings.Index(o, "*")
+			p.wildcard = append(p.wildcard, [2]string{strings.ToLower(o[:idx]), strings.ToLower(o[idx+1:])})
+		default:
+			p.exact[strings.ToLower(o)] = true
+		}
+	}
+	for _, expr := range config.AllowOriginRegexps {
+		p.regexps = append(p.regexps, regexp.MustCompile("^(?:"+expr+")$"))
+	}
+
+	for _, h := range config.AllowHeaders {
+		if h == "*" {
+			p.anyHdr = true
+		}
+		p.headers[strings.ToLower(h)] = true
+	}
+	methods := make([]string, 0, len(config.AllowMethods))
+	for _, m := range config.AllowMethods {
+		m = strings.ToUpper(m)
+		p.methods[m] = true
+		methods = append(methods, m)
+	}
+
+	p.allowMethods = strings.Join(methods, ", ")
+	p.allowHeaders = strings.Join(config.AllowHeaders, ", ")
+	p.exposeHeaders = strings.Join(config.ExposeHeaders, ", ")
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(config.MaxAge.Seconds()))
+	}
+	return p
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrig {
+		return true
+	}
+
+	lower := strings.ToLower(origin)
+	if p.exact[lower] {
+		return true
+	}
+	for _, w := range p.wildcard {
+		// the wildcard has to match at least one character, e.g. https://*.a.com doesn't match https://.a.com
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, re := range p.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) allowRequestHeaders(requested string) bool {
+	if p.anyHdr || requested == "" {
+		return true
+	}
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" && !p.headers[h] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORS(t *testing.T) {
+	config := NewCORSConfig()
+	config.AllowOrigins = []string{"https://app.example.com", "https://*.example.org"}
+	config.AllowOriginRegexps = []string{`^https://[a-z]+\.example\.net$`, `https://[a-z]+\.example\.io`}
+	config.AllowCredentials = true
+
+	r := gin.New()
+	r.Use(CORS(config))
+	r.GET("/api", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":      true,
+		"https://a.b.example.org":      true,
+		"https://.example.org":         false,
+		"https://api.example.net":      true,
+		"https://evil.com":             false,
+		"https://evilexample.org":      false,
+		"https://app.example.com.x":    false,
+		"https://api.example.io":       true,
+		"https://api.example.io.x":     false,
+		"https://x.com/api.example.io": false,
+	} {
+		w := doRequest(r, http.MethodGet, "/api", map[string]string{"Origin": origin})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expect 200, got %d", origin, w.Code)
+		}
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if allowed && (got != origin || w.Header().Get("Access-Control-Allow-Credentials") != "true") {
+			t.Errorf("%s: expect origin to be echoed with credentials, got %q", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Errorf("%s: expect no allow origin, got %q", origin, got)
+		}
+	}
+
+	preflight := map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, authorization",
+	}
+	w := doRequest(r, http.MethodOptions, "/api", preflight)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") == "" ||
+		w.Header().Get("Access-Control-Max-Age") != "43200" {
+		t.Fatalf("unexpected preflight response %d %v", w.Code, w.Header())
+	}
+
+	preflight["Access-Control-Request-Headers"] = "x-unknown"
+	if w := doRequest(r, http.MethodOptions, "/api", preflight); w.Code != http.StatusForbidden {
+		t.Fatalf("expect 403 for a header not allowed, got %d", w.Code)
+	}
+
+	preflight["Origin"] = "https://evil.com"
+	if w := doRequest(r, http.MethodOptions, "/api", preflight); w.Code != http.StatusForbidden {
+		t.Fatalf("expect 403 for an origin not allowed, got %d", w.Code)
+	}
+}
+
+func TestCORSAnyOriginWithCredentials(t *testing.T) {
+	config := NewCORSConfig()
+	config.AllowOrigins = []string{"*"}
+	config.AllowCredentials = true
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect CORS to reject \"*\" with credentials")
+		}
+	}()
+	CORS(config)
+}
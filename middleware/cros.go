@@ -1,21 +0,0 @@
-package middleware
-
-import (
-	"github.com/gin-gonic/gin"
-	"net/http"
-)
-
-func Cros(c *gin.Context) {
-	method := c.Request.Method
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token")
-	c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
-	c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
-	c.Header("Access-Control-Allow-Credentials", "true")
-
-	if method == "OPTIONS" {
-		c.AbortWithStatus(http.StatusNoContent)
-	}
-
-	c.Next()
-}
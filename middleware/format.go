@@ -1 +1,196 @@
 package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+const (
+	RequestIDKey = "basego.request_id"
+
+	CodeOK = 0
+)
+
+// Response is the standard json envelope of every api
+type Response struct {
+	Code      int         `json:"code"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+/*
+应用错误，Status为HTTP状态码，Code为返回给调用方的业务错误码。
+Err为内部原因，不会返回给调用方。
+*/
+type Error struct {
+	Status  int
+	Code    int
+	Message string
+	Err     error
+}
+
+func NewError(status int, code int, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithMessage returns a copy of e with another message
+func (e *Error) WithMessage(message string) *Error {
+	ret := *e
+	ret.Message = message
+	return &ret
+}
+
+// Wrap returns a copy of e caused by err
+func (e *Error) Wrap(err error) *Error {
+	ret := *e
+	ret.Err = err
+	return &ret
+}
+
+var (
+	ErrBadRequest      = NewError(http.StatusBadRequest, http.StatusBadRequest, "bad request")
+	ErrUnauthorized    = NewError(http.StatusUnauthorized, http.StatusUnauthorized, "unauthorized")
+	ErrForbidden       = NewError(http.StatusForbidden, http.StatusForbidden, "forbidden")
+	ErrNotFound        = NewError(http.StatusNotFound, http.StatusNotFound, "not found")
+	ErrConflict        = NewError(http.StatusConflict, http.StatusConflict, "conflict")
+	ErrPayloadTooLarge = NewError(http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "payload too large")
+	ErrTooManyRequests = NewError(http.StatusTooManyRequests, http.StatusTooManyRequests, "too many requests")
+	ErrInternal        = NewError(http.StatusInternalServerError, http.StatusInternalServerError, "internal server error")
+)
+
+// OK writes data in a success envelope with status 200
+func OK(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusOK, &Response{
+		Code:      CodeOK,
+		Message:   "ok",
+		Data:      data,
+		RequestID: requestID(c),
+	})
+}
+
+// Fail aborts the request with the envelope of err, errors other than *Error and binding errors become 500
+func Fail(c *gin.Context, err error) {
+	e := toError(err)
+	c.AbortWithStatusJSON(e.Status, &Response{
+		Code:      e.Code,
+		Message:   e.Message,
+		RequestID: requestID(c),
+	})
+}
+
+/*
+统一错误格式: handler通过c.Error(err)记录的错误在handler返回后按Fail的规则输出，
+handler中的panic和Recovery一样处理，返回500。
+*/
+func Format() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				handlePanic(c, rec)
+			}
+		}()
+
+		w := &formatWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		// c.Bind aborts with 400 before the error is recorded, the status is only decided here
+		if len(c.Errors) == 0 || c.Writer.Size() > 0 {
+			if w.headerNow {
+				c.Writer.WriteHeaderNow()
+			}
+			return
+		}
+		last := c.Errors.Last()
+		if last.IsType(gin.ErrorTypeBind) && toBindError(last.Err) == nil {
+			Fail(c, ErrBadRequest.Wrap(last.Err))
+			return
+		}
+		Fail(c, last.Err)
+	}
+}
+
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if e = toBindError(err); e != nil {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
+
+// toBindError maps the errors of gin binding to ErrBadRequest (ErrPayloadTooLarge for a body over BodyLimit), nil if err isn't one
+func toBindError(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	switch {
+	case errors.As(err, &validationErrs):
+		if len(validationErrs) == 0 {
+			return ErrBadRequest.Wrap(err)
+		}
+		// ValidationErrors is a map, report the first field by namespace so the message is stable
+		keys := make([]string, 0, len(validationErrs))
+		for k := range validationErrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fe := validationErrs[keys[0]]
+		return ErrBadRequest.WithMessage(fmt.Sprintf("invalid field %s: failed on %s", fe.Field, fe.Tag)).Wrap(err)
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.ErrUnexpectedEOF):
+		// a truncated body fails with io.ErrUnexpectedEOF instead of a SyntaxError
+		return ErrBadRequest.WithMessage("invalid json body").Wrap(err)
+	case errors.As(err, &numErr):
+		// form and query binding parse numbers and bools with strconv
+		return ErrBadRequest.WithMessage("invalid parameter").Wrap(err)
+	case errors.Is(err, io.EOF):
+		return ErrBadRequest.WithMessage("empty body").Wrap(err)
+	case errors.Is(err, ErrPayloadTooLarge):
+		// BodyLimit fails the read of c.Bind
+		return ErrPayloadTooLarge
+	}
+	return nil
+}
+
+// formatWriter delays the header of c.AbortWithStatus until a body is written or the handlers return
+type formatWriter struct {
+	gin.ResponseWriter
+	headerNow bool
+}
+
+func (w *formatWriter) WriteHeaderNow() {
+	w.headerNow = true
+}
+
+func (w *formatWriter) Written() bool {
+	return w.headerNow || w.ResponseWriter.Written()
+}
+
+func requestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFormat(t *testing.T) {
+	type order struct {
+		ID    int    `json:"id" binding:"required"`
+		Owner string `json:"owner" binding:"required"`
+	}
+
+	errOutOfStock := NewError(http.StatusConflict, 10001, "out of stock")
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(RequestIDKey, "req-1")
+	}, Format())
+	r.GET("/ok", func(c *gin.Context) {
+		OK(c, gin.H{"id": 1})
+	})
+	r.GET("/typed", func(c *gin.Context) {
+		c.Error(errOutOfStock.Wrap(errors.New("stock=0")))
+	})
+	r.GET("/plain", func(c *gin.Context) {
+		Fail(c, errors.New("db is down"))
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	r.POST("/should-bind", func(c *gin.Context) {
+		var o order
+		if err := c.ShouldBindJSON(&o); err != nil {
+			Fail(c, err)
+			return
+		}
+		OK(c, o)
+	})
+	r.GET("/should-bind-query", func(c *gin.Context) {
+		var q struct {
+			Page int `form:"page"`
+		}
+		if err := c.ShouldBindQuery(&q); err != nil {
+			Fail(c, err)
+			return
+		}
+		OK(c, q)
+	})
+	r.POST("/bind", func(c *gin.Context) {
+		var o order
+		if c.BindJSON(&o) == nil {
+			OK(c, o)
+		}
+	})
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   int
+		msg    string
+	}{
+		{http.MethodGet, "/ok", "", http.StatusOK, CodeOK, "ok"},
+		{http.MethodGet, "/typed", "", http.StatusConflict, 10001, "out of stock"},
+		{http.MethodGet, "/plain", "", http.StatusInternalServerError, http.StatusInternalServerError, "internal server error"},
+		{http.MethodGet, "/panic", "", http.StatusInternalServerError, http.StatusInternalServerError, "internal server error"},
+		{http.MethodPost, "/should-bind", `{"id":1}`, http.StatusBadRequest, http.StatusBadRequest, "invalid field Owner: failed on required"},
+		{http.MethodPost, "/should-bind", `{}`, http.StatusBadRequest, http.StatusBadRequest, "invalid field ID: failed on required"},
+		{http.MethodPost, "/should-bind", `{"id":"x","owner":"a"}`, http.StatusBadRequest, http.StatusBadRequest, "invalid json body"},
+		{http.MethodPost, "/should-bind", `{"id":`, http.StatusBadRequest, http.StatusBadRequest, "invalid json body"},
+		{http.MethodGet, "/should-bind-query?page=x", "", http.StatusBadRequest, http.StatusBadRequest, "invalid parameter"},
+		{http.MethodGet, "/should-bind-query?page=2", "", http.StatusOK, CodeOK, "ok"},
+		{http.MethodPost, "/bind", `{"id":`, http.StatusBadRequest, http.StatusBadRequest, "invalid json body"},
+		{http.MethodPost, "/bind", `{"id":1}`, http.StatusBadRequest, http.StatusBadRequest, "invalid field Owner: failed on required"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("%s: expect status %d, got %d", c.path, c.status, w.Code)
+			continue
+		}
+		var resp Response
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: invalid body %q", c.path, w.Body.String())
+			continue
+		}
+		if resp.Code != c.code || resp.Message != c.msg || resp.RequestID != "req-1" {
+			t.Errorf("%s: unexpected response %+v", c.path, resp)
+		}
+	}
+}
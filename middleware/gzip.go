@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GzipConfig struct {
+	// responses shorter than MinLength are sent uncompressed
+	MinLength int
+	Level     int
+	// content types not compressed, matched by prefix
+	ExcludedContentTypes []string
+	SkipPaths            []string
+}
+
+func NewGzipConfig() *GzipConfig {
+	return &GzipConfig{
+		MinLength: 1024,
+		Level:     gzip.DefaultCompression,
+		ExcludedContentTypes: []string{
+			"image/", "video/", "audio/", "text/event-stream",
+			"application/zip", "application/gzip", "application/x-gzip", "application/octet-stream",
+		},
+	}
+}
+
+/*
+客户端支持gzip且响应体不小于MinLength时压缩响应。
+响应先缓冲到MinLength再决定是否压缩，handler调用Flush(流式响应)时不再压缩。
+*/
+func Gzip(config *GzipConfig) gin.HandlerFunc {
+	if _, err := gzip.NewWriterLevel(nil, config.Level); err != nil {
+		panic("middleware: " + err.Error())
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, config.Level)
+		return gz
+	}}
+
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) ||
+			c.Request.Method == http.MethodHead ||
+			!acceptsGzip(c.GetHeader("Accept-Encoding")) ||
+			strings.Contains(strings.ToLower(c.GetHeader("Connection")), "upgrade") {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipWriter{ResponseWriter: c.Writer, config: config, pool: pool}
+		c.Writer = gw
+		defer func() {
+			gw.finish()
+			c.Writer = gw.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if accepted {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	gzipBuffering = iota
+	gzipCompressing
+	gzipPassthrough
+)
+
+type gzipWriter struct {
+	gin.ResponseWriter
+	config *GzipConfig
+	pool   *sync.Pool
+
+	mode      int
+	buf       bytes.Buffer
+	gz        *gzip.Writer
+	headerNow bool
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	switch w.mode {
+	case gzipCompressing:
+		return w.gz.Write(data)
+	case gzipPassthrough:
+		return w.ResponseWriter.Write(data)
+	}
+
+	n, _ := w.buf.Write(data)
+	if w.buf.Len() >= w.config.MinLength {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow is delayed until the response is known to be compressed or not
+func (w *gzipWriter) WriteHeaderNow() {
+	if w.mode != gzipBuffering {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.headerNow = true
+}
+
+func (w *gzipWriter) Written() bool {
+	if w.mode == gzipBuffering {
+		return w.headerNow || w.buf.Len() > 0
+	}
+	return w.ResponseWriter.Written()
+}
+
+// Size is the uncompressed size while buffering, the bytes sent afterwards
+func (w *gzipWriter) Size() int {
+	if w.mode == gzipBuffering && w.Written() {
+		return w.buf.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+// Flush marks a streaming response, which is never compressed
+func (w *gzipWriter) Flush() {
+	switch w.mode {
+	case gzipBuffering:
+		w.passthrough()
+	case gzipCompressing:
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) decide() error {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || !w.compressible(header.Get("Content-Type")) {
+		return w.passthrough()
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.mode = gzipCompressing
+	w.gz = w.pool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *gzipWriter) compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, excluded := range w.config.ExcludedContentTypes {
+		if strings.HasPrefix(contentType, excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *gzipWriter) passthrough() error {
+	w.mode = gzipPassthrough
+	if w.headerNow {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *gzipWriter) finish() {
+	switch w.mode {
+	case gzipBuffering:
+		w.passthrough()
+	case gzipCompressing:
+		w.gz.Close()
+		w.gz.Reset(nil)
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGzip(t *testing.T) {
+	config := NewGzipConfig()
+	config.MinLength = 100
+	large := strings.Repeat("basego ", 100)
+
+	r := gin.New()
+	r.Use(Gzip(config))
+	r.GET("/large", func(c *gin.Context) { c.String(http.StatusOK, large) })
+	r.GET("/small", func(c *gin.Context) { c.String(http.StatusOK, "small") })
+	r.GET("/image", func(c *gin.Context) { c.Data(http.StatusOK, "image/png", []byte(large)) })
+	r.GET("/stream", func(c *gin.Context) {
+		c.Writer.WriteString("event 1\n")
+		c.Writer.Flush()
+		c.Writer.WriteString(large)
+	})
+	r.GET("/empty", func(c *gin.Context) { c.AbortWithStatus(http.StatusNotFound) })
+
+	get := func(path string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/large", "deflate, gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expect gzip, got headers %v", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, _ := ioutil.ReadAll(gz)
+	if string(body) != large {
+		t.Fatal("unexpected decompressed body")
+	}
+
+	cases := []struct {
+		path   string
+		accept string
+		expect string
+	}{
+		{"/large", "gzip;q=0", large},
+		{"/large", "", large},
+		{"/small", "gzip", "small"},
+		{"/image", "gzip", large},
+		{"/stream", "gzip", "event 1\n" + large},
+	}
+	for _, c := range cases {
+		w := get(c.path, c.accept)
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != c.expect {
+			t.Errorf("%s %q: expect uncompressed, got %v", c.path, c.accept, w.Header())
+		}
+	}
+
+	if w := get("/empty", "gzip"); w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Fatalf("expect empty 404, got %d %q", w.Code, w.Body.String())
+	}
+}
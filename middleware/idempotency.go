@@ -0,0 +1,296 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	ErrIdempotencyInFlight = errors.New("request with the same idempotency key is in progress")
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with a different request")
+)
+
+// StoredResponse is the first response of an idempotency key
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+/*
+幂等记录的存储，多实例部署时需要使用共享的实现(如redis)。
+Begin原子地占用key: 已完成时返回保存的响应，处理中返回ErrIdempotencyInFlight，
+fingerprint不一致返回ErrIdempotencyMismatch。lockTTL用于进程崩溃后释放占用。
+*/
+type IdempotencyStore interface {
+	Begin(ctx context.Context, key string, fingerprint string, lockTTL time.Duration) (*StoredResponse, error)
+	Complete(ctx context.Context, key string, resp *StoredResponse, ttl time.Duration) error
+	// Release drops an in-flight key so that the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+type IdempotencyConfig struct {
+	Store IdempotencyStore
+	// how long responses are replayed
+	TTL time.Duration
+	// how long a key stays in flight if the instance never completes it
+	LockTTL time.Duration
+	// only requests of these methods with the header are handled
+	Methods []string
+	// max body read to fingerprint the request
+	MaxBodySize int64
+}
+
+func NewIdempotencyConfig(store IdempotencyStore) *IdempotencyConfig {
+	return &IdempotencyConfig{
+		Store:       store,
+		TTL:         24 * time.Hour,
+		LockTTL:     time.Minute,
+		Methods:     []string{http.MethodPost},
+		MaxBodySize: 10 << 20,
+	}
+}
+
+const maxIdempotencyKeyLen = 255
+
+/*
+按Idempotency-Key和用户保存第一次请求的响应(状态码、响应头和响应体)，重复的请求直接返回保存的响应，
+原请求还在处理时返回409。5xx、panic和通过c.Error/Fail交给Format渲染的错误响应不保存，客户端可以用相同的key重试。
+只保存handler设置的响应头，重放时不覆盖外层中间件为本次请求设置的响应头(如X-Request-ID、限流和CORS)。
+用户只取自认证中间件设置的Identity，需要放在认证中间件之后，没有Identity的请求返回401。
+*/
+func Idempotency(config *IdempotencyConfig) gin.HandlerFunc {
+	if config.Store == nil {
+		panic("middleware: Idempotency needs a store")
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || !containsString(config.Methods, c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			Fail(c, ErrBadRequest.WithMessage("idempotency key too long"))
+			return
+		}
+
+		// anonymous callers would share one key space and could replay each other's responses
+		identity, ok := GetIdentity(c)
+		if !ok || identity.User == "" {
+			abortUnauthorized(c, "idempotency key needs an authenticated user")
+			return
+		}
+		key = identity.User + "|" + key
+
+		body, err := readBody(c.Request, config.MaxBodySize)
+		if err != nil {
+			if err == errBodyTooLarge {
+				Fail(c, ErrPayloadTooLarge)
+				return
+			}
+			Fail(c, ErrBadRequest.Wrap(err))
+			return
+		}
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + "\n" + c.Request.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		ctx := c.Request.Context()
+		stored, err := config.Store.Begin(ctx, key, fingerprint, config.LockTTL)
+		switch {
+		case err == ErrIdempotencyInFlight:
+			Fail(c, ErrConflict.WithMessage(err.Error()))
+			return
+		case err == ErrIdempotencyMismatch:
+			Fail(c, NewError(http.StatusUnprocessableEntity, http.StatusUnprocessableEntity, err.Error()))
+			return
+		case err != nil:
+			Fail(c, err)
+			return
+		case stored != nil:
+			replay(c, stored)
+			return
+		}
+
+		rec := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = rec
+		// headers set before this point belong to this request, not to the stored response
+		before := rec.Header().Clone()
+		completed := false
+		defer func() {
+			c.Writer = rec.ResponseWriter
+			if !completed {
+				config.Store.Release(context.Background(), key)
+			}
+		}()
+
+		c.Next()
+
+		// errors are rendered by Format after this returns, the recorded response would be empty
+		status := rec.Status()
+		if len(c.Errors) > 0 || status >= http.StatusInternalServerError {
+			return
+		}
+		resp := &StoredResponse{
+			Status: status,
+			Header: handlerHeader(before, rec.Header()),
+			Body:   rec.body.Bytes(),
+		}
+		if err := config.Store.Complete(context.Background(), key, resp, config.TTL); err == nil {
+			completed = true
+		}
+	}
+}
+
+func replay(c *gin.Context, resp *StoredResponse) {
+	header := c.Writer.Header()
+	for k, v := range resp.Header {
+		if _, ok := header[k]; ok || requestScopedHeader(k) {
+			continue
+		}
+		header[k] = v
+	}
+	header.Set("Idempotent-Replayed", "true")
+	c.Status(resp.Status)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Write(resp.Body)
+	c.Abort()
+}
+
+// handlerHeader returns the headers added or changed since before
+func handlerHeader(before http.Header, after http.Header) http.Header {
+	header := make(http.Header)
+	for k, v := range after {
+		if old, ok := before[k]; ok && equalStrings(old, v) {
+			continue
+		}
+		header[k] = append([]string(nil), v...)
+	}
+	return header
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestScopedHeader reports whether k describes a single request and must not be replayed
+func requestScopedHeader(k string) bool {
+	k = http.CanonicalHeaderKey(k)
+	return k == http.CanonicalHeaderKey(RequestIDHeader) || k == "Vary" || k == "Retry-After" ||
+		strings.HasPrefix(k, "X-Ratelimit-") || strings.HasPrefix(k, "Access-Control-")
+}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.body.Write(data[:n])
+	return n, err
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	resp        *StoredResponse
+	expires     time.Time
+}
+
+// MemoryIdempotencyStore keeps the responses in process, expired entries are dropped as new keys come in
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, key string, fingerprint string, lockTTL time.Duration) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		if entry.fingerprint != fingerprint {
+			return nil, ErrIdempotencyMismatch
+		}
+		if entry.resp == nil {
+			return nil, ErrIdempotencyInFlight
+		}
+		return entry.resp, nil
+	}
+
+	s.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(lockTTL)}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, resp *StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return errors.New("idempotency key not in flight")
+	}
+	entry.resp = resp
+	entry.expires = s.now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.resp == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if !now.Before(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
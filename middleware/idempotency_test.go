@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotency(t *testing.T) {
+	var created, failures int32
+	block := make(chan struct{})
+	entered := make(chan struct{})
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("x-test-user"); user != "" {
+			c.Set(IdentityKey, &Identity{User: user})
+		}
+	}, Idempotency(NewIdempotencyConfig(NewMemoryIdempotencyStore())))
+	r.POST("/orders", func(c *gin.Context) {
+		n := atomic.AddInt32(&created, 1)
+		c.Header("X-Order", strconv.Itoa(int(n)))
+		c.String(http.StatusCreated, "order %d", n)
+	})
+	r.POST("/payments", func(c *gin.Context) {
+		if atomic.AddInt32(&failures, 1) == 1 {
+			c.Status(http.StatusBadGateway)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/slow", func(c *gin.Context) {
+		close(entered)
+		<-block
+		c.Status(http.StatusOK)
+	})
+
+	post := func(path string, key string, user string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		if user != "" {
+			req.Header.Set("x-test-user", user)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/orders", "k1", "alice", "{}")
+	second := post("/orders", "k1", "alice", "{}")
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated || second.Body.String() != "order 1" ||
+		second.Header().Get("X-Order") != "1" || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expect replayed response, got %d %s %v", second.Code, second.Body.String(), second.Header())
+	}
+	if w := post("/orders", "k1", "bob", "{}"); w.Body.String() != "order 2" {
+		t.Fatalf("expect keys scoped by user, got %s", w.Body.String())
+	}
+	if w := post("/orders", "k1", "", "{}"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect anonymous requests rejected, got %d", w.Code)
+	}
+	spoofed := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+	spoofed.Header.Set(IdempotencyKeyHeader, "k1")
+	spoofed.Header.Set("x-forward-user", "alice")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, spoofed)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect the user header to be ignored, got %d", w.Code)
+	}
+	if w := post("/orders", "k1", "alice", `{"a":1}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expect 422 for a different body, got %d", w.Code)
+	}
+
+	if w := post("/payments", "p1", "alice", ""); w.Code != http.StatusBadGateway {
+		t.Fatalf("expect 502, got %d", w.Code)
+	}
+	if w := post("/payments", "p1", "alice", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expect failed response not stored, got %d", w.Code)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("/slow", "s1", "alice", "") }()
+	<-entered
+	if w := post("/slow", "s1", "alice", ""); w.Code != http.StatusConflict {
+		t.Fatalf("expect 409 while in flight, got %d", w.Code)
+	}
+	close(block)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+	if atomic.LoadInt32(&created) != 2 {
+		t.Fatalf("expect 2 orders created, got %d", created)
+	}
+}
+
+func TestIdempotencyChain(t *testing.T) {
+	var calls int32
+	r := gin.New()
+	r.Use(RequestID(), Format(), AuthToken(NewAuthConfig(StaticTokenVerifier{"alice": "secret"})),
+		Idempotency(NewIdempotencyConfig(NewMemoryIdempotencyStore())))
+	r.POST("/orders", func(c *gin.Context) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			c.Error(errors.New("db is down"))
+		case 2:
+			c.Error(ErrBadRequest)
+		default:
+			c.Header("X-Order", "3")
+			OK(c, gin.H{"id": 3})
+		}
+	})
+
+	post := func(key string, requestID string) *httptest.ResponseRecorder {
+		return doRequest(r, http.MethodPost, "/orders", map[string]string{
+			IdempotencyKeyHeader: key, RequestIDHeader: requestID,
+			"x-forward-user": "alice", "authentication": "secret",
+		})
+	}
+
+	// errors rendered by Format are not stored
+	if w := post("k1", "r1"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expect 500, got %d %s", w.Code, w.Body.String())
+	}
+	if w := post("k1", "r2"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expect the 500 not replayed, got %d %s", w.Code, w.Body.String())
+	}
+	first := post("k1", "r3")
+	if first.Code != http.StatusOK {
+		t.Fatalf("expect the 400 not replayed, got %d %s", first.Code, first.Body.String())
+	}
+
+	second := post("k1", "r4")
+	if second.Code != http.StatusOK || second.Body.String() != first.Body.String() || second.Header().Get("X-Order") != "3" ||
+		second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expect replayed response, got %d %s %v", second.Code, second.Body.String(), second.Header())
+	}
+	if values := second.Header().Values(RequestIDHeader); len(values) != 1 || values[0] != "r4" {
+		t.Fatalf("expect the request id of the replay, got %v", values)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("expect 3 calls, got %d", calls)
+	}
+}
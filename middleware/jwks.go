@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"basego/logger"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// oct
+	K string `json:"k"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a parsed jwk, key is []byte, *rsa.PublicKey or *ecdsa.PublicKey
+type verificationKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+/*
+从本地JWKS文件加载验签公钥(或HS256的对称密钥)，定时检查文件的修改时间，
+文件变化时重新加载，方便轮换密钥。重新加载失败时继续使用旧的密钥。
+*/
+type JWKSFile struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    []*verificationKey
+	modTime time.Time
+	size    int64
+
+	quit chan struct{}
+}
+
+// NewJWKSFile loads path and re-reads it every interval if it changed, interval 0 disables reloading
+func NewJWKSFile(path string, interval time.Duration) (*JWKSFile, error) {
+	ks := &JWKSFile{
+		path: path,
+		quit: make(chan struct{}),
+	}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		go ks.watch(interval)
+	}
+	return ks, nil
+}
+
+func (ks *JWKSFile) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !ks.changed() {
+				continue
+			}
+			if err := ks.Reload(); err != nil {
+				logger.Error("reload jwks failed", err.Error(), map[string]interface{}{"path": ks.path})
+			}
+		case <-ks.quit:
+			return
+		}
+	}
+}
+
+func (ks *JWKSFile) changed() bool {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return false
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return !info.ModTime().Equal(ks.modTime) || info.Size() != ks.size
+}
+
+// Reload reads the file right away
+func (ks *JWKSFile) Reload() error {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	ks.size = info.Size()
+	ks.mu.Unlock()
+
+	return nil
+}
+
+func (ks *JWKSFile) Close() {
+	close(ks.quit)
+}
+
+// lookup returns the keys usable for alg, only the one named kid if kid is not empty
+func (ks *JWKSFile) lookup(kid string, alg string) []*verificationKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	ret := make([]*verificationKey, 0, 1)
+	for _, k := range ks.keys {
+		if k.alg != alg || (kid != "" && k.kid != kid) {
+			continue
+		}
+		ret = append(ret, k)
+	}
+	return ret
+}
+
+func parseJWKS(data []byte) ([]*verificationKey, error) {
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*verificationKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// one unsupported key (e.g. RS384 or P-384) doesn't stop the others from being used
+		key, err := parseJWK(k)
+		if err != nil {
+			logger.Warn("skip unusable jwk", err.Error(), map[string]interface{}{"kid": k.Kid, "kty": k.Kty, "alg": k.Alg})
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable signing keys")
+	}
+	return keys, nil
+}
+
+// parseJWK binds every key to a single algorithm so that a token can't pick a weaker one
+func parseJWK(k *jwk) (*verificationKey, error) {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid k")
+		}
+		return newVerificationKey(k, "HS256", secret)
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 {
+			return nil, errors.New("invalid n or e")
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return newVerificationKey(k, "RS256", pub)
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("invalid x or y")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return newVerificationKey(k, "ES256", pub)
+	default:
+		return nil, fmt.Errorf("unsupported kty %s", k.Kty)
+	}
+}
+
+func newVerificationKey(k *jwk, alg string, key interface{}) (*verificationKey, error) {
+	if k.Alg != "" && k.Alg != alg {
+		return nil, fmt.Errorf("alg %s doesn't match kty %s", k.Alg, k.Kty)
+	}
+	return &verificationKey{kid: k.Kid, alg: alg, key: key}, nil
+}
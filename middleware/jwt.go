@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+const ClaimsKey = "basego.jwt_claims"
+
+var (
+	ErrTokenMalformed   = errors.New("malformed token")
+	ErrTokenSignature   = errors.New("invalid token signature")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	ErrTokenIssuer      = errors.New("invalid token issuer")
+	ErrTokenAudience    = errors.New("invalid token audience")
+)
+
+// Claims holds the registered claims, every claim including custom ones is kept in Raw
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Raw       map[string]interface{}
+}
+
+// String returns a custom string claim
+func (cl *Claims) String(name string) string {
+	s, _ := cl.Raw[name].(string)
+	return s
+}
+
+// Strings returns a custom claim holding a string or a list of strings
+func (cl *Claims) Strings(name string) []string {
+	switch v := cl.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+type JWTConfig struct {
+	Keys *JWKSFile
+	// empty Issuer or Audience is not checked
+	Issuer   string
+	Audience string
+	// clock skew allowed when checking exp and nbf
+	Leeway time.Duration
+	// claim holding the roles of Identity
+	RolesClaim string
+	SkipPaths  []string
+}
+
+func NewJWTConfig(keys *JWKSFile) *JWTConfig {
+	return &JWTConfig{
+		Keys:       keys,
+		Leeway:     time.Minute,
+		RolesClaim: "roles",
+	}
+}
+
+/*
+校验 Authorization: Bearer <token> 中的JWT，支持HS256/RS256/ES256。
+校验通过后Claims通过GetClaims获取，同时设置Identity(User为sub)。
+*/
+func JWT(config *JWTConfig) gin.HandlerFunc {
+	if config.Keys == nil {
+		panic("middleware: JWT needs a key set")
+	}
+
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		auth := c.GetHeader("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			abortUnauthorized(c, "missing bearer token")
+			return
+		}
+
+		claims, err := config.parse(strings.TrimSpace(auth[7:]), time.Now())
+		if err != nil {
+			// the cause may name keys and algorithms, it is only logged
+			logger.Warn("verify jwt failed", err.Error(), map[string]interface{}{"request_id": requestID(c)})
+			abortUnauthorized(c, ErrInvalidToken.Error())
+			return
+		}
+
+		c.Set(ClaimsKey, claims)
+		c.Set(IdentityKey, &Identity{
+			User:  claims.Subject,
+			Roles: claims.Strings(config.RolesClaim),
+			Extra: claims.Raw,
+		})
+		c.Next()
+	}
+}
+
+// GetClaims returns the claims of the token verified by the JWT middleware
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	v, ok := c.Get(ClaimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(*Claims)
+	return claims, ok
+}
+
+func (config *JWTConfig) parse(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	keys := config.Keys.lookup(header.Kid, header.Alg)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key for alg %s and kid %s", header.Alg, header.Kid)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, k := range keys {
+		if verifySignature(k, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrTokenSignature
+	}
+
+	raw := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims, err := newClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(config.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if !claims.NotBefore.IsZero() && now.Add(config.Leeway).Before(claims.NotBefore) {
+		return nil, ErrTokenNotValidYet
+	}
+	if config.Issuer != "" && claims.Issuer != config.Issuer {
+		return nil, ErrTokenIssuer
+	}
+	if config.Audience != "" && !containsString(claims.Audience, config.Audience) {
+		return nil, ErrTokenAudience
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func verifySignature(k *verificationKey, signed []byte, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch key := k.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+func newClaims(raw map[string]interface{}) (*Claims, error) {
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.ID, _ = raw["jti"].(string)
+	claims.Audience = claims.Strings("aud")
+
+	dates := map[string]*time.Time{"exp": &claims.ExpiresAt, "nbf": &claims.NotBefore, "iat": &claims.IssuedAt}
+	for name, t := range dates {
+		v, ok := raw[name]
+		if !ok {
+			continue
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, ErrTokenMalformed
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, ErrTokenMalformed
+		}
+		// split before converting, nanoseconds since 1970 overflow int64 after 2262
+		sec, frac := math.Modf(f)
+		*t = time.Unix(int64(sec), int64(frac*1e9))
+	}
+	return claims, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err.Error())
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path,
+		map[string]string{"kty": "oct", "kid": "hs", "k": b64(secret)},
+		map[string]string{"kty": "RSA", "kid": "rs", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		map[string]string{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+	)
+	keys, err := NewJWKSFile(path, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	config := NewJWTConfig(keys)
+	config.Issuer = "sso"
+	config.Audience = "orders"
+
+	r := gin.New()
+	r.Use(JWT(config))
+	r.GET("/me", func(c *gin.Context) {
+		claims, _ := GetClaims(c)
+		identity, _ := GetIdentity(c)
+		c.String(http.StatusOK, claims.Subject+":"+identity.Roles[0])
+	})
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"iss": "sso", "aud": []string{"orders"}, "sub": "alice", "roles": []string{"admin"},
+		"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(30 * time.Second).Unix(),
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		claims := make(map[string]interface{})
+		for key, val := range valid {
+			claims[key] = val
+		}
+		claims[k] = v
+		return claims
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"hs256", signToken(t, "HS256", "hs", secret, valid), http.StatusOK},
+		{"rs256", signToken(t, "RS256", "rs", rsaKey, valid), http.StatusOK},
+		{"es256", signToken(t, "ES256", "es", ecKey, valid), http.StatusOK},
+		{"no kid", signToken(t, "RS256", "", rsaKey, valid), http.StatusOK},
+		{"alg mismatch", signToken(t, "HS256", "rs", secret, valid), http.StatusUnauthorized},
+		{"bad signature", signToken(t, "HS256", "hs", []byte("other"), valid), http.StatusUnauthorized},
+		{"expired", signToken(t, "HS256", "hs", secret, with("exp", now.Add(-2*time.Minute).Unix())), http.StatusUnauthorized},
+		{"expired within leeway", signToken(t, "HS256", "hs", secret, with("exp", now.Add(-30*time.Second).Unix())), http.StatusOK},
+		{"not before", signToken(t, "HS256", "hs", secret, with("nbf", now.Add(2*time.Minute).Unix())), http.StatusUnauthorized},
+		{"issuer", signToken(t, "HS256", "hs", secret, with("iss", "other")), http.StatusUnauthorized},
+		{"audience", signToken(t, "HS256", "hs", secret, with("aud", "billing")), http.StatusUnauthorized},
+		{"malformed", "abc.def", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		w := doRequest(r, http.MethodGet, "/me", map[string]string{"Authorization": "Bearer " + c.token})
+		if w.Code != c.code {
+			t.Errorf("%s: expect %d, got %d %s", c.name, c.code, w.Code, w.Body.String())
+		}
+		if c.code == http.StatusOK && w.Body.String() != "alice:admin" {
+			t.Errorf("%s: unexpected body %s", c.name, w.Body.String())
+		}
+		// the cause is logged, not returned
+		if c.code == http.StatusUnauthorized && !strings.Contains(w.Body.String(), `"message":"invalid token"`) {
+			t.Errorf("%s: unexpected body %s", c.name, w.Body.String())
+		}
+	}
+
+	// rotate: drop the HS256 key
+	writeJWKS(t, path, map[string]string{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())})
+	if err := keys.Reload(); err != nil {
+		t.Fatal(err.Error())
+	}
+	w := doRequest(r, http.MethodGet, "/me", map[string]string{"Authorization": "Bearer " + signToken(t, "HS256", "hs", secret, valid)})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect rotated key to be rejected, got %d", w.Code)
+	}
+}
+
+func TestParseJWKSSkipsUnusableKeys(t *testing.T) {
+	secret := b64([]byte("0123456789abcdef"))
+	usable := map[string]string{"kty": "oct", "kid": "hs", "k": secret}
+
+	cases := []struct {
+		name   string
+		keys   []map[string]string
+		expect int
+	}{
+		{"rs384", []map[string]string{usable, {"kty": "RSA", "kid": "rs", "alg": "RS384", "n": "AQAB", "e": "AQAB"}}, 1},
+		{"p-384", []map[string]string{usable, {"kty": "EC", "kid": "es", "crv": "P-384", "x": "AQAB", "y": "AQAB"}}, 1},
+		{"alg mismatch", []map[string]string{{"kty": "oct", "kid": "bad", "alg": "RS256", "k": secret}, usable}, 1},
+		{"encryption key", []map[string]string{usable, {"kty": "oct", "kid": "enc", "use": "enc", "k": secret}}, 1},
+		{"none usable", []map[string]string{{"kty": "EC", "kid": "es", "crv": "P-384", "x": "AQAB", "y": "AQAB"}}, 0},
+	}
+	for _, c := range cases {
+		data, _ := json.Marshal(map[string]interface{}{"keys": c.keys})
+		keys, err := parseJWKS(data)
+		if c.expect == 0 {
+			if err == nil {
+				t.Errorf("%s: expect error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err.Error())
+			continue
+		}
+		if len(keys) != c.expect || keys[0].kid != "hs" {
+			t.Errorf("%s: unexpected keys %v", c.name, keys)
+		}
+	}
+}
+
+func TestNewClaimsDates(t *testing.T) {
+	// 2286-11-20, past the int64 nanosecond range
+	claims, err := newClaims(map[string]interface{}{"exp": json.Number("10000000000.5")})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if claims.ExpiresAt.Unix() != 10000000000 || claims.ExpiresAt.Nanosecond() != 500000000 {
+		t.Fatalf("unexpected exp %v", claims.ExpiresAt)
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/metrics"
+)
+
+// requests not matching any route share this route label to keep the number of series bounded
+const unmatchedRoute = "unmatched"
+
+type MetricsConfig struct {
+	Registry *metrics.Registry
+	// prefix of the metric names
+	Namespace string
+	// latency buckets in seconds, metrics.DefBuckets if empty
+	Buckets []float64
+	// exact match or prefix ending with /*
+	SkipPaths []string
+}
+
+func NewMetricsConfig() *MetricsConfig {
+	return &MetricsConfig{
+		Registry:  metrics.Default,
+		Namespace: "http",
+		SkipPaths: []string{"/metrics"},
+	}
+}
+
+/*
+记录请求数、延迟直方图和处理中的请求数，标签为method、路由模板(route)和状态码分类(status，如2xx)。
+r用于判断请求是否匹配了路由，需要在注册完路由之后才开始处理请求。
+
+	r := gin.New()
+	r.Use(middleware.Metrics(r, middleware.NewMetricsConfig()))
+	r.GET("/metrics", middleware.MetricsHandler(metrics.Default))
+*/
+func Metrics(r *gin.Engine, config *MetricsConfig) gin.HandlerFunc {
+	ns := config.Namespace
+	requests := config.Registry.NewCounter(ns+"_requests_total", "Total number of http requests.", "method", "route", "status")
+	latency := config.Registry.NewHistogram(ns+"_request_duration_seconds", "Latency of http requests in seconds.", config.Buckets, "method", "route", "status")
+	inFlight := config.Registry.NewGauge(ns+"_requests_in_flight", "Number of http requests being served.", "method", "route")
+
+	resolver := newRouteResolver(r)
+
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		method := c.Request.Method
+		route, ok := resolver.resolve(c)
+		if !ok {
+			route = unmatchedRoute
+		}
+
+		inFlight.Inc(method, route)
+		start := time.Now()
+		defer func() {
+			inFlight.Dec(method, route)
+		}()
+
+		c.Next()
+
+		status := strconv.Itoa(c.Writer.Status()/100) + "xx"
+		requests.Inc(method, route, status)
+		latency.Observe(time.Since(start).Seconds(), method, route, status)
+	}
+}
+
+// MetricsHandler serves the metrics of registry in the Prometheus text format
+func MetricsHandler(registry *metrics.Registry) gin.HandlerFunc {
+	return gin.WrapH(registry)
+}
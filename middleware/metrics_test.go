@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	config := NewMetricsConfig()
+	config.Registry = registry
+
+	r := gin.New()
+	r.Use(Metrics(r, config))
+	r.GET("/metrics", MetricsHandler(registry))
+	r.GET("/orders/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	doRequest(r, http.MethodGet, "/orders/1", nil)
+	doRequest(r, http.MethodGet, "/orders/2", nil)
+	doRequest(r, http.MethodGet, "/missing/3", nil)
+
+	w := doRequest(r, http.MethodGet, "/metrics", nil)
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/orders/:id",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/orders/:id",status="2xx"} 2`,
+		`http_requests_in_flight{method="GET",route="/orders/:id"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expect %s in:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `route="/metrics"`) {
+		t.Error("expect /metrics skipped")
+	}
+}
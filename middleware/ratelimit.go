@@ -0,0 +1,343 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+/*
+限流规则: 每Period最多Limit个请求。
+TokenBucket按Limit/Period的速率补充令牌，最多积累Burst个(默认Limit)，允许突发；
+SlidingWindow用前一个窗口的计数按时间加权估算最近Period内的请求数。
+*/
+type RateLimit struct {
+	Algorithm string
+	Limit     int
+	Period    time.Duration
+	Burst     int
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until the quota is fully restored
+	ResetAfter time.Duration
+	// time until the next request can be allowed, 0 if Allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the counters, implementations shared between instances (e.g. redis) must apply limit atomically
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, limit *RateLimit) (*RateLimitResult, error)
+}
+
+type KeyFunc func(c *gin.Context) string
+
+// KeyByIP uses the address of the peer, X-Forwarded-For and X-Real-Ip are ignored as any client can set them
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + remoteIP(c.Request)
+}
+
+// KeyByUser uses the authenticated user, falls back to KeyByIP for anonymous requests
+func KeyByUser(c *gin.Context) string {
+	if identity, ok := GetIdentity(c); ok && identity.User != "" {
+		return "user:" + identity.User
+	}
+	return KeyByIP(c)
+}
+
+/*
+KeyByIPBehind用于部署在代理之后: 只有请求来自proxies(CIDR，如"10.0.0.0/8")中的代理时才读取X-Forwarded-For和X-Real-Ip，
+X-Forwarded-For从右往左取第一个不属于代理的地址。CIDR无效时panic。
+*/
+func KeyByIPBehind(proxies ...string) KeyFunc {
+	trusted := parseCIDRs(proxies)
+	return func(c *gin.Context) string {
+		return "ip:" + forwardedIP(c.Request, trusted)
+	}
+}
+
+// KeyByUserBehind is KeyByUser falling back to KeyByIPBehind(proxies...) for anonymous requests
+func KeyByUserBehind(proxies ...string) KeyFunc {
+	byIP := KeyByIPBehind(proxies...)
+	return func(c *gin.Context) string {
+		if identity, ok := GetIdentity(c); ok && identity.User != "" {
+			return "user:" + identity.User
+		}
+		return byIP(c)
+	}
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic("middleware: invalid trusted proxy " + cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedIP returns the client address given by trusted proxies, the peer address otherwise
+func forwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := remoteIP(r)
+	if ip := net.ParseIP(remote); ip == nil || !containsIP(trusted, ip) {
+		return remote
+	}
+
+	// every proxy appends the address it received the request from, entries left of the last untrusted one may be forged
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if i == 0 || !containsIP(trusted, ip) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); ip != nil && len(hops) == 0 {
+		return ip.String()
+	}
+	return remote
+}
+
+type RateLimitConfig struct {
+	Store RateLimitStore
+	// default limit, requests are not limited if nil
+	Limit *RateLimit
+	// limits of specific routes, keyed by method and route template, e.g. "POST /orders/:id",
+	// routes with parameters are only matched when RouteTemplate is installed
+	Routes  map[string]*RateLimit
+	KeyFunc KeyFunc
+	// exact match or prefix ending with /*
+	SkipPaths []string
+}
+
+func NewRateLimitConfig(store RateLimitStore, limit *RateLimit) *RateLimitConfig {
+	return &RateLimitConfig{
+		Store:   store,
+		Limit:   limit,
+		Routes:  make(map[string]*RateLimit),
+		KeyFunc: KeyByIP,
+	}
+}
+
+// RateLimiter rejects requests over the limit with 429, requests are allowed if the store fails
+func RateLimiter(config *RateLimitConfig) gin.HandlerFunc {
+	if config.Store == nil {
+		panic("middleware: RateLimiter needs a store")
+	}
+
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		limit, scope := config.Limit, ""
+		if len(config.Routes) > 0 {
+			if route, ok := routeKey(c); ok {
+				if l, ok := config.Routes[route]; ok {
+					limit, scope = l, route
+				}
+			}
+		}
+		if limit == nil {
+			c.Next()
+			return
+		}
+
+		key := config.KeyFunc(c)
+		if scope != "" {
+			key = scope + "|" + key
+		}
+		ret, err := config.Store.Allow(c.Request.Context(), key, limit)
+		if err != nil {
+			logger.Error("rate limit store failed", err.Error(), map[string]interface{}{"key": key, "request_id": requestID(c)})
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(ret.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(ret.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(ret.ResetAfter)))
+		if !ret.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(ret.RetryAfter)))
+			Fail(c, ErrTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type rateLimitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+	// sliding window
+	window   time.Time
+	count    int
+	previous int
+	// after expires the entry is the same as a new one and can be dropped
+	expires time.Time
+}
+
+// MemoryRateLimitStore keeps counters in process, entries idle for a while are dropped
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		entries: make(map[string]*rateLimitEntry),
+		now:     time.Now,
+	}
+}
+
+const rateLimitSweepInterval = time.Minute
+
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string, limit *RateLimit) (*RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	entry, ok := s.entries[key]
+	if !ok {
+		entry = &rateLimitEntry{}
+		s.entries[key] = entry
+	}
+
+	if limit.Algorithm == SlidingWindow {
+		ret := entry.slidingWindow(now, limit)
+		// both windows have slid out
+		entry.expires = entry.window.Add(2 * limit.Period)
+		return ret, nil
+	}
+	ret := entry.tokenBucket(now, limit)
+	// the bucket is full again
+	entry.expires = now.Add(ret.ResetAfter)
+	return ret, nil
+}
+
+// sweep drops the expired entries, at most once every sweep interval
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (e *rateLimitEntry) tokenBucket(now time.Time, limit *RateLimit) *RateLimitResult {
+	capacity := float64(limit.Burst)
+	if capacity <= 0 {
+		capacity = float64(limit.Limit)
+	}
+	// tokens per nanosecond
+	rate := float64(limit.Limit) / float64(limit.Period)
+
+	if e.last.IsZero() {
+		e.tokens = capacity
+	} else {
+		e.tokens = math.Min(capacity, e.tokens+float64(now.Sub(e.last))*rate)
+	}
+	e.last = now
+
+	ret := &RateLimitResult{Limit: int(capacity)}
+	if e.tokens >= 1 {
+		e.tokens--
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = time.Duration(math.Ceil((1 - e.tokens) / rate))
+	}
+	ret.Remaining = int(e.tokens)
+	ret.ResetAfter = time.Duration(math.Ceil((capacity - e.tokens) / rate))
+	return ret
+}
+
+func (e *rateLimitEntry) slidingWindow(now time.Time, limit *RateLimit) *RateLimitResult {
+	window := now.Truncate(limit.Period)
+	switch {
+	case window.Equal(e.window):
+	case window.Sub(e.window) == limit.Period:
+		e.previous, e.count = e.count, 0
+	default:
+		e.previous, e.count = 0, 0
+	}
+	e.window = window
+
+	elapsed := now.Sub(window)
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	estimated := float64(e.previous)*weight + float64(e.count)
+
+	ret := &RateLimitResult{Limit: limit.Limit, ResetAfter: limit.Period - elapsed}
+	if estimated+1 <= float64(limit.Limit) {
+		e.count++
+		estimated++
+		ret.Allowed = true
+	} else if e.previous > 0 && float64(e.count) < float64(limit.Limit) {
+		// wait until enough of the previous window slides out
+		need := estimated + 1 - float64(limit.Limit)
+		ret.RetryAfter = time.Duration(math.Ceil(need / float64(e.previous) * float64(limit.Period)))
+	} else {
+		ret.RetryAfter = limit.Period - elapsed
+	}
+	ret.Remaining = int(math.Max(0, float64(limit.Limit)-estimated))
+	if e.previous > 0 {
+		ret.ResetAfter += limit.Period
+	}
+	return ret
+}
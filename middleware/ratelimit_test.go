@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTokenBucket(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Unix(1000, 0)
+	store.now = func() time.Time { return now }
+	limit := &RateLimit{Algorithm: TokenBucket, Limit: 2, Period: time.Second, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if ret, _ := store.Allow(context.Background(), "k", limit); !ret.Allowed || ret.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected %+v", i, ret)
+		}
+	}
+	ret, _ := store.Allow(context.Background(), "k", limit)
+	if ret.Allowed || ret.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("expect rejected with retry after 500ms, got %+v", ret)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ret, _ := store.Allow(context.Background(), "k", limit); !ret.Allowed {
+		t.Fatalf("expect a refilled token, got %+v", ret)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Unix(960, 0)
+	store.now = func() time.Time { return now }
+	limit := &RateLimit{Algorithm: SlidingWindow, Limit: 4, Period: time.Minute}
+
+	for i := 0; i < 4; i++ {
+		if ret, _ := store.Allow(context.Background(), "k", limit); !ret.Allowed {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	if ret, _ := store.Allow(context.Background(), "k", limit); ret.Allowed {
+		t.Fatal("expect 5th request rejected")
+	}
+
+	// a quarter into the next window 3 of the previous 4 requests still count
+	now = now.Add(time.Minute + 15*time.Second)
+	if ret, _ := store.Allow(context.Background(), "k", limit); !ret.Allowed {
+		t.Fatalf("expect allowed, got %+v", ret)
+	}
+	ret, _ := store.Allow(context.Background(), "k", limit)
+	if ret.Allowed || ret.RetryAfter != 15*time.Second {
+		t.Fatalf("expect rejected with retry after 15s, got %+v", ret)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if ret, _ := store.Allow(context.Background(), "k", limit); !ret.Allowed || ret.Remaining != 3 {
+		t.Fatalf("expect a fresh window, got %+v", ret)
+	}
+}
+
+func TestMemoryRateLimitStoreSweep(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Unix(86400*10, 0)
+	store.now = func() time.Time { return now }
+	daily := &RateLimit{Algorithm: SlidingWindow, Limit: 1, Period: 24 * time.Hour}
+	perSecond := &RateLimit{Algorithm: TokenBucket, Limit: 1, Period: time.Second}
+
+	if ret, _ := store.Allow(context.Background(), "daily", daily); !ret.Allowed {
+		t.Fatal("expect the first daily request allowed")
+	}
+
+	// a sweep triggered by a short period must not drop the daily counter
+	now = now.Add(2 * time.Minute)
+	store.Allow(context.Background(), "second", perSecond)
+	if ret, _ := store.Allow(context.Background(), "daily", daily); ret.Allowed {
+		t.Fatal("expect the daily limit to survive the sweep")
+	}
+
+	now = now.Add(2 * time.Minute)
+	store.Allow(context.Background(), "daily", daily)
+	if _, ok := store.entries["second"]; ok || len(store.entries) != 1 {
+		t.Fatalf("expect only the expired entry to be swept, got %v", store.entries)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	config := NewRateLimitConfig(NewMemoryRateLimitStore(), &RateLimit{Algorithm: SlidingWindow, Limit: 2, Period: time.Hour})
+	config.Routes["POST /orders/:id"] = &RateLimit{Algorithm: TokenBucket, Limit: 1, Period: time.Hour}
+	config.KeyFunc = KeyByUser
+
+	r := gin.New()
+	r.Use(RouteTemplate(r), func(c *gin.Context) {
+		c.Set(IdentityKey, &Identity{User: c.GetHeader("x-forward-user")})
+	}, RateLimiter(config))
+	r.GET("/orders/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/orders/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	alice := map[string]string{"x-forward-user": "alice"}
+	bob := map[string]string{"x-forward-user": "bob"}
+
+	for i, expect := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := doRequest(r, http.MethodGet, "/orders/1", alice)
+		if w.Code != expect {
+			t.Fatalf("get %d: expect %d, got %d", i, expect, w.Code)
+		}
+		if expect == http.StatusTooManyRequests && (w.Header().Get("Retry-After") == "" || w.Header().Get("X-RateLimit-Remaining") != "0") {
+			t.Fatalf("unexpected headers %v", w.Header())
+		}
+	}
+	if w := doRequest(r, http.MethodGet, "/orders/2", bob); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Fatalf("expect bob to have his own quota, got %d", w.Code)
+	}
+
+	// the route limit is counted separately
+	if w := doRequest(r, http.MethodPost, "/orders/1", alice); w.Code != http.StatusOK {
+		t.Fatalf("expect post allowed, got %d", w.Code)
+	}
+	if w := doRequest(r, http.MethodPost, "/orders/2", alice); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expect post limited, got %d", w.Code)
+	}
+}
+
+func TestKeyByIP(t *testing.T) {
+	behind := KeyByIPBehind("10.0.0.0/8")
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		key     KeyFunc
+		expect  string
+	}{
+		{"peer", "203.0.113.9:4000", nil, KeyByIP, "ip:203.0.113.9"},
+		{"spoofed forwarded for", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, KeyByIP, "ip:203.0.113.9"},
+		{"spoofed real ip", "203.0.113.9:4000", map[string]string{"X-Real-Ip": "198.51.100.1"}, KeyByIP, "ip:203.0.113.9"},
+		{"untrusted peer", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, behind, "ip:203.0.113.9"},
+		{"trusted proxy", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, behind, "ip:198.51.100.1"},
+		{"forged hop", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.0.0.3"}, behind, "ip:198.51.100.1"},
+		{"real ip", "10.0.0.2:4000", map[string]string{"X-Real-Ip": "198.51.100.1"}, behind, "ip:198.51.100.1"},
+		{"invalid forwarded for", "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "unknown"}, behind, "ip:10.0.0.2"},
+		{"anonymous user", "203.0.113.9:4000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, KeyByUser, "ip:203.0.113.9"},
+	}
+	for _, tc := range cases {
+		var got string
+		r := gin.New()
+		r.GET("/", func(c *gin.Context) { got = tc.key(c) })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.expect {
+			t.Errorf("%s: expect %s, got %s", tc.name, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime/debug"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+// PanicInfo describes a panic recovered from a handler
+type PanicInfo struct {
+	Value     interface{}
+	Stack     []byte
+	Method    string
+	Path      string
+	Route     string
+	RequestID string
+}
+
+/*
+panic后调用的钩子，在响应写出之后同步执行，耗时的操作应该自己起goroutine。
+例如通过kafka发送告警:
+
+	middleware.RegisterPanicHook(func(c *gin.Context, info *middleware.PanicInfo) {
+		go kafka.Put2Topic("alert", map[string]interface{}{
+			"route": info.Route, "request_id": info.RequestID, "panic": fmt.Sprint(info.Value),
+		})
+	})
+*/
+type PanicHook func(c *gin.Context, info *PanicInfo)
+
+var panicHooks []PanicHook
+
+// RegisterPanicHook adds a hook called by Recovery and Format, it must be called before serving
+func RegisterPanicHook(hook PanicHook) {
+	panicHooks = append(panicHooks, hook)
+}
+
+/*
+捕获handler中的panic，通过logger.Error记录调用栈、请求ID和路由，返回500的统一格式响应，
+然后调用注册的PanicHook。客户端断开连接导致的panic只记录日志。
+*/
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				handlePanic(c, rec)
+			}
+		}()
+		c.Next()
+	}
+}
+
+func handlePanic(c *gin.Context, rec interface{}) {
+	info := &PanicInfo{
+		Value:     rec,
+		Stack:     debug.Stack(),
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Route:     routeTemplate(c),
+		RequestID: requestID(c),
+	}
+
+	logger.Error("panic recovered", fmt.Sprint(rec), map[string]interface{}{
+		"method":     info.Method,
+		"path":       info.Path,
+		"route":      info.Route,
+		"request_id": info.RequestID,
+		"stack":      string(info.Stack),
+	})
+
+	if brokenPipe(rec) || c.Writer.Written() {
+		c.Abort()
+	} else {
+		Fail(c, ErrInternal)
+	}
+
+	for _, hook := range panicHooks {
+		runPanicHook(hook, c, info)
+	}
+}
+
+func runPanicHook(hook PanicHook, c *gin.Context, info *PanicInfo) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			logger.Error("panic hook failed", fmt.Sprint(rec), map[string]interface{}{"request_id": info.RequestID})
+		}
+	}()
+	hook(c, info)
+}
+
+// brokenPipe reports whether the panic comes from writing to a client that went away
+func brokenPipe(rec interface{}) bool {
+	err, ok := rec.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var sysErr *os.SyscallError
+	if errors.As(opErr, &sysErr) && (sysErr.Err == syscall.EPIPE || sysErr.Err == syscall.ECONNRESET) {
+		return true
+	}
+	msg := strings.ToLower(opErr.Error())
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	var got *PanicInfo
+	old := panicHooks
+	defer func() { panicHooks = old }()
+	RegisterPanicHook(func(c *gin.Context, info *PanicInfo) {
+		panic("broken hook")
+	})
+	RegisterPanicHook(func(c *gin.Context, info *PanicInfo) {
+		got = info
+	})
+
+	r := gin.New()
+	r.Use(RequestID(), RouteTemplate(r), Recovery())
+	r.GET("/orders/:id", func(c *gin.Context) {
+		var m map[string]int
+		m["x"] = 1
+	})
+
+	w := doRequest(r, http.MethodGet, "/orders/9", map[string]string{RequestIDHeader: "req-9"})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expect 500, got %d", w.Code)
+	}
+	var resp Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.RequestID != "req-9" || resp.Code != http.StatusInternalServerError {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+
+	if got == nil {
+		t.Fatal("expect hook called")
+	}
+	if got.Route != "/orders/:id" || got.RequestID != "req-9" || !strings.Contains(string(got.Stack), "recovery_test.go") {
+		t.Fatalf("unexpected panic info %+v", got)
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+	"basego/toolkit"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// ids longer than this or with unprintable characters are replaced, they end up in logs and headers
+const maxRequestIDLen = 128
+
+/*
+沿用请求头X-Request-ID中的请求ID，没有时生成一个UUID，并写入响应头。
+请求ID同时保存在gin.Context(GetRequestID)和c.Request.Context()(logger.RequestIDFromContext)中，
+下游调用和logger.InfoCtx等可以直接使用请求的context。
+*/
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = toolkit.NewUUID()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(logger.ContextWithRequestID(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+func GetRequestID(c *gin.Context) string {
+	return requestID(c)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
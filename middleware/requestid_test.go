@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+func TestRequestID(t *testing.T) {
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/id", func(c *gin.Context) {
+		c.String(http.StatusOK, GetRequestID(c)+"|"+logger.RequestIDFromContext(c.Request.Context()))
+	})
+
+	w := doRequest(r, http.MethodGet, "/id", map[string]string{RequestIDHeader: "abc-123"})
+	if w.Body.String() != "abc-123|abc-123" || w.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Fatalf("expect incoming id to be kept, got %s %s", w.Body.String(), w.Header().Get(RequestIDHeader))
+	}
+
+	for _, incoming := range []string{"", "bad id\n", strings.Repeat("a", 200)} {
+		w = doRequest(r, http.MethodGet, "/id", map[string]string{RequestIDHeader: incoming})
+		id := w.Header().Get(RequestIDHeader)
+		if len(id) != 36 || w.Body.String() != id+"|"+id {
+			t.Errorf("expect a generated id for %q, got %s %s", incoming, id, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+// RouteKey is where RouteTemplate stores the route template of the request
+const RouteKey = "basego.route"
+
+/*
+gin v1.4没有FullPath，RouteTemplate从r.Routes()中找出请求匹配的路由模板(如/orders/42 -> /orders/:id)，
+供AccessLog、RateLimiter、Timeout、BodyLimit和Recovery使用，需要放在它们之前，
+并在注册完路由之后才开始处理请求。
+
+	r := gin.New()
+	r.Use(middleware.RouteTemplate(r), middleware.AccessLog(middleware.NewAccessLogConfig()))
+*/
+func RouteTemplate(r *gin.Engine) gin.HandlerFunc {
+	resolver := newRouteResolver(r)
+
+	return func(c *gin.Context) {
+		if route, ok := resolver.resolve(c); ok {
+			c.Set(RouteKey, route)
+		}
+		c.Next()
+	}
+}
+
+// routeResolver indexes the routes of the engine on first use, when they have all been registered
+type routeResolver struct {
+	engine *gin.Engine
+
+	once sync.Once
+	// routes by method and number of segments
+	routes map[string]map[int][]routePattern
+	// routes ending with a catch-all parameter, by method
+	catchAll map[string][]routePattern
+}
+
+type routePattern struct {
+	template string
+	segs     []string
+}
+
+func newRouteResolver(r *gin.Engine) *routeResolver {
+	return &routeResolver{engine: r}
+}
+
+func (rr *routeResolver) load() {
+	rr.routes = make(map[string]map[int][]routePattern)
+	rr.catchAll = make(map[string][]routePattern)
+	for _, info := range rr.engine.Routes() {
+		pattern := routePattern{template: info.Path, segs: strings.Split(info.Path, "/")}
+		last := pattern.segs[len(pattern.segs)-1]
+		if last != "" && last[0] == '*' {
+			rr.catchAll[info.Method] = append(rr.catchAll[info.Method], pattern)
+			continue
+		}
+		if rr.routes[info.Method] == nil {
+			rr.routes[info.Method] = make(map[int][]routePattern)
+		}
+		n := len(pattern.segs)
+		rr.routes[info.Method][n] = append(rr.routes[info.Method][n], pattern)
+	}
+}
+
+/*
+gin不允许同一位置同时注册固定段和参数(如/orders/list和/orders/:id)，
+所以段数相同、固定段相同且参数值与c.Params一致的路由模板是唯一的。
+*/
+func (rr *routeResolver) resolve(c *gin.Context) (string, bool) {
+	rr.once.Do(rr.load)
+
+	segs := strings.Split(c.Request.URL.Path, "/")
+	for _, pattern := range rr.routes[c.Request.Method][len(segs)] {
+		if pattern.match(segs, c.Params) {
+			return pattern.template, true
+		}
+	}
+	for _, pattern := range rr.catchAll[c.Request.Method] {
+		if len(segs) >= len(pattern.segs) && pattern.match(segs, c.Params) {
+			return pattern.template, true
+		}
+	}
+	return "", false
+}
+
+// match compares the segments of the pattern with the first segments of the path
+func (p routePattern) match(segs []string, params gin.Params) bool {
+	for i, seg := range p.segs {
+		switch {
+		case seg == "" || (seg[0] != ':' && seg[0] != '*'):
+			if segs[i] != seg {
+				return false
+			}
+		case seg[0] == ':':
+			if value, ok := params.Get(seg[1:]); !ok || value != segs[i] {
+				return false
+			}
+		default:
+			// the value of a catch-all parameter starts with the / before it
+			value, ok := params.Get(seg[1:])
+			return ok && value == "/"+strings.Join(segs[i:], "/")
+		}
+	}
+	return true
+}
+
+/*
+routeTemplate返回RouteTemplate找到的路由模板。没有使用RouteTemplate时只有不带参数的路径就是路由模板，
+带参数时无法可靠还原，返回""。
+*/
+func routeTemplate(c *gin.Context) string {
+	if route := c.GetString(RouteKey); route != "" {
+		return route
+	}
+	if len(c.Params) == 0 {
+		return c.Request.URL.Path
+	}
+	return ""
+}
+
+var noRouteTemplate sync.Once
+
+// routeKey returns "METHOD template" to look up per-route config, false if the route template is unknown
+func routeKey(c *gin.Context) (string, bool) {
+	route := routeTemplate(c)
+	if route == "" {
+		noRouteTemplate.Do(func() {
+			logger.Warn("route template unknown, per-route config is skipped", "install RouteTemplate before the middlewares",
+				map[string]interface{}{"path": c.Request.URL.Path, "request_id": requestID(c)})
+		})
+		return "", false
+	}
+	return c.Request.Method + " " + route, true
+}
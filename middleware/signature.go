@@ -0,0 +1,325 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"basego/logger"
+)
+
+const (
+	AccessKeyHeader = "X-Access-Key"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+	SignatureHeader = "X-Signature"
+)
+
+var ErrUnknownAccessKey = errors.New("unknown access key")
+
+var (
+	errNonceUsed      = errors.New("nonce already used")
+	errNonceCacheFull = errors.New("nonce cache is full")
+)
+
+// SecretStore returns the signing secret of an access key
+type SecretStore interface {
+	Secret(ctx context.Context, accessKey string) ([]byte, error)
+}
+
+type StaticSecrets map[string]string
+
+func (s StaticSecrets) Secret(ctx context.Context, accessKey string) ([]byte, error) {
+	secret, ok := s[accessKey]
+	if !ok {
+		return nil, ErrUnknownAccessKey
+	}
+	return []byte(secret), nil
+}
+
+type SignatureConfig struct {
+	Secrets SecretStore
+	// requests whose timestamp is further than Window from now are rejected
+	Window time.Duration
+	// max unexpired nonces remembered per access key, a key sending more than NonceCacheSize requests
+	// within 2*Window (about 166/s sustained with the defaults) is rejected with 429 until its nonces expire,
+	// the other keys are not affected
+	NonceCacheSize int
+	// max body read for hashing, larger requests are rejected with 413
+	MaxBodySize int64
+	SkipPaths   []string
+}
+
+func NewSignatureConfig(secrets SecretStore) *SignatureConfig {
+	return &SignatureConfig{
+		Secrets:        secrets,
+		Window:         5 * time.Minute,
+		NonceCacheSize: 100000,
+		MaxBodySize:    10 << 20,
+	}
+}
+
+/*
+服务间调用的签名校验，签名为
+hex(HMAC-SHA256(secret, method\n请求路径(含query)\ntimestamp\nnonce\nhex(sha256(body))))，
+放在X-Signature中，密钥通过X-Access-Key查找。
+timestamp超出Window的请求被拒绝，Window内重复的nonce视为重放。
+校验通过后设置Identity，User为access key。
+*/
+func VerifySignature(config *SignatureConfig) gin.HandlerFunc {
+	if config.Secrets == nil {
+		panic("middleware: VerifySignature needs a SecretStore")
+	}
+	nonces := newNonceCaches(config.NonceCacheSize, 2*config.Window)
+
+	return func(c *gin.Context) {
+		if matchPath(config.SkipPaths, c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		accessKey := c.GetHeader(AccessKeyHeader)
+		timestamp := c.GetHeader(TimestampHeader)
+		nonce := c.GetHeader(NonceHeader)
+		signature, err := hex.DecodeString(c.GetHeader(SignatureHeader))
+		if accessKey == "" || timestamp == "" || nonce == "" || err != nil || len(signature) == 0 {
+			abortUnauthorized(c, "missing signature")
+			return
+		}
+
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		now := time.Now()
+		if err != nil || absDuration(now.Sub(time.Unix(sec, 0))) > config.Window {
+			abortUnauthorized(c, "timestamp out of window")
+			return
+		}
+
+		secret, err := config.Secrets.Secret(c.Request.Context(), accessKey)
+		if err != nil {
+			// the same answer as a wrong signature, neither store errors nor unknown keys are told to the client
+			logger.Warn("load signature secret failed", err.Error(), map[string]interface{}{"access_key": accessKey, "request_id": requestID(c)})
+			abortUnauthorized(c, "invalid signature")
+			return
+		}
+
+		body, err := readBody(c.Request, config.MaxBodySize)
+		if err != nil {
+			if err == errBodyTooLarge {
+				Fail(c, ErrPayloadTooLarge)
+				return
+			}
+			Fail(c, ErrBadRequest.Wrap(err))
+			return
+		}
+
+		expect := sign(secret, c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+		if !hmac.Equal(signature, expect) {
+			abortUnauthorized(c, "invalid signature")
+			return
+		}
+		// checked after the signature so that unsigned requests can't flush the cache
+		if err := nonces.add(accessKey, nonce, now); err != nil {
+			if err == errNonceCacheFull {
+				// dropping a nonce that hasn't expired would let its request be replayed
+				logger.Warn("reject signed request", err.Error(), map[string]interface{}{"access_key": accessKey, "request_id": requestID(c)})
+				Fail(c, ErrTooManyRequests)
+				return
+			}
+			abortUnauthorized(c, err.Error())
+			return
+		}
+
+		if _, ok := GetIdentity(c); !ok {
+			c.Set(IdentityKey, &Identity{User: accessKey})
+		}
+		c.Next()
+	}
+}
+
+func sign(secret []byte, method string, uri string, timestamp string, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+uri+"\n"+timestamp+"\n"+nonce+"\n"+hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil)
+}
+
+var errBodyTooLarge = errors.New("body too large")
+
+// readBody reads the body and puts it back for the handlers
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// nonceCaches gives every access key its own nonceCache, so one key filling its cache doesn't lock out the others.
+// Keys are only added after their signature is verified.
+type nonceCaches struct {
+	mu     sync.Mutex
+	size   int
+	ttl    time.Duration
+	caches map[string]*nonceCache
+}
+
+func newNonceCaches(size int, ttl time.Duration) *nonceCaches {
+	return &nonceCaches{
+		size:   size,
+		ttl:    ttl,
+		caches: make(map[string]*nonceCache),
+	}
+}
+
+func (n *nonceCaches) add(accessKey string, nonce string, now time.Time) error {
+	n.mu.Lock()
+	cache, ok := n.caches[accessKey]
+	if !ok {
+		cache = newNonceCache(n.size, n.ttl)
+		n.caches[accessKey] = cache
+	}
+	n.mu.Unlock()
+	return cache.add(nonce, now)
+}
+
+// nonceCache remembers nonces for ttl, holding at most size of them. Only expired nonces are dropped.
+type nonceCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List
+}
+
+type nonceEntry struct {
+	key     string
+	expires time.Time
+}
+
+func newNonceCache(size int, ttl time.Duration) *nonceCache {
+	return &nonceCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// add fails if key was added within ttl, or if size nonces within ttl are held already
+func (nc *nonceCache) add(key string, now time.Time) error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	// entries are in insertion order, so the expired ones are at the front
+	for e := nc.order.Front(); e != nil; e = nc.order.Front() {
+		if entry := e.Value.(*nonceEntry); now.Before(entry.expires) {
+			break
+		}
+		nc.remove(e)
+	}
+
+	if _, ok := nc.items[key]; ok {
+		return errNonceUsed
+	}
+	if nc.order.Len() >= nc.size {
+		return errNonceCacheFull
+	}
+	nc.items[key] = nc.order.PushBack(&nonceEntry{key: key, expires: now.Add(nc.ttl)})
+	return nil
+}
+
+func (nc *nonceCache) remove(e *list.Element) {
+	nc.order.Remove(e)
+	delete(nc.items, e.Value.(*nonceEntry).key)
+}
+
+// Signer signs outgoing requests for VerifySignature
+type Signer struct {
+	AccessKey string
+	Secret    []byte
+}
+
+func NewSigner(accessKey string, secret string) *Signer {
+	return &Signer{AccessKey: accessKey, Secret: []byte(secret)}
+}
+
+// Sign sets the signature headers of req, the body is read and replaced
+func (s *Signer) Sign(req *http.Request) error {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonceStr := hex.EncodeToString(nonce)
+
+	req.Header.Set(AccessKeyHeader, s.AccessKey)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonceStr)
+	req.Header.Set(SignatureHeader, hex.EncodeToString(sign(s.Secret, req.Method, req.URL.RequestURI(), timestamp, nonceStr, body)))
+	return nil
+}
+
+// Transport returns a RoundTripper signing every request before sending it through base, http.DefaultTransport if nil
+func (s *Signer) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &signingTransport{signer: s, base: base}
+}
+
+type signingTransport struct {
+	signer *Signer
+	base   http.RoundTripper
+}
+
+// RoundTrip signs a clone, a RoundTripper must not modify the request
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	clone := req.Clone(req.Context())
+	if err := t.signer.Sign(clone); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(clone)
+}
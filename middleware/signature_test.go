@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifySignature(t *testing.T) {
+	config := NewSignatureConfig(StaticSecrets{"billing": "s3cret"})
+	config.Window = time.Minute
+
+	r := gin.New()
+	r.Use(VerifySignature(config))
+	r.POST("/orders", func(c *gin.Context) {
+		identity, _ := GetIdentity(c)
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, identity.User+":"+string(body))
+	})
+
+	signed := func(signer *Signer, uri string, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, uri, strings.NewReader(body))
+		if err := signer.Sign(req); err != nil {
+			t.Fatal(err.Error())
+		}
+		return req
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	signer := NewSigner("billing", "s3cret")
+	req := signed(signer, "/orders?id=1", `{"amount":1}`)
+	if w := serve(req); w.Code != http.StatusOK || w.Body.String() != `billing:{"amount":1}` {
+		t.Fatalf("expect 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	// replay
+	replay := httptest.NewRequest(http.MethodPost, "/orders?id=1", strings.NewReader(`{"amount":1}`))
+	replay.Header = req.Header.Clone()
+	if w := serve(replay); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "nonce") {
+		t.Fatalf("expect replay rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	tampered := signed(signer, "/orders?id=1", `{"amount":1}`)
+	tampered.Body = ioutil.NopCloser(strings.NewReader(`{"amount":100}`))
+	if w := serve(tampered); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect tampered body rejected, got %d", w.Code)
+	}
+
+	tampered = signed(signer, "/orders?id=1", "")
+	tampered.URL.RawQuery = "id=2"
+	if w := serve(tampered); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect tampered query rejected, got %d", w.Code)
+	}
+
+	if w := serve(signed(NewSigner("billing", "wrong"), "/orders", "")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expect wrong secret rejected, got %d", w.Code)
+	}
+	// an unknown key gets the same answer as a wrong secret
+	if w := serve(signed(NewSigner("unknown", "s3cret"), "/orders", "")); w.Code != http.StatusUnauthorized ||
+		!strings.Contains(w.Body.String(), `"message":"invalid signature"`) {
+		t.Fatalf("expect unknown key rejected, got %d %s", w.Code, w.Body.String())
+	}
+
+	stale := signed(signer, "/orders", "")
+	stale.Header.Set(TimestampHeader, strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10))
+	if w := serve(stale); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "window") {
+		t.Fatalf("expect stale request rejected, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSigningTransport(t *testing.T) {
+	r := gin.New()
+	r.Use(VerifySignature(NewSignatureConfig(StaticSecrets{"billing": "s3cret"})))
+	r.PUT("/orders/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewSigner("billing", "s3cret").Transport(nil)}
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/orders/1", strings.NewReader("{}"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expect 204, got %d", resp.StatusCode)
+	}
+}
+
+func TestNonceCachesPerAccessKey(t *testing.T) {
+	nc := newNonceCaches(1, time.Minute)
+	now := time.Now()
+
+	if nc.add("billing", "a", now) != nil || nc.add("billing", "b", now) != errNonceCacheFull {
+		t.Fatal("expect the cache of billing to be full")
+	}
+	if err := nc.add("orders", "a", now); err != nil {
+		t.Fatalf("expect another access key not to be affected, got %v", err)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	nc := newNonceCache(2, time.Minute)
+	now := time.Now()
+
+	if nc.add("a", now) != nil || nc.add("a", now) != errNonceUsed {
+		t.Fatal("expect duplicate rejected")
+	}
+	nc.add("b", now.Add(time.Second))
+	if err := nc.add("c", now.Add(time.Second)); err != errNonceCacheFull {
+		t.Fatalf("expect a full cache to reject, got %v", err)
+	}
+	if nc.add("a", now.Add(time.Second)) != errNonceUsed {
+		t.Fatal("expect an unexpired nonce to be kept when the cache is full")
+	}
+
+	// a expires, b is still held
+	later := now.Add(time.Minute)
+	if nc.add("c", later) != nil || nc.add("b", later) != errNonceUsed {
+		t.Fatal("expect only the expired nonce dropped")
+	}
+	if nc.add("b", later.Add(time.Second)) != nil {
+		t.Fatal("expect expired nonce accepted")
+	}
+}
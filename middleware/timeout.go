@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrTimeout = NewError(http.StatusGatewayTimeout, http.StatusGatewayTimeout, "request timeout")
+
+type TimeoutConfig struct {
+	// 0 disables the default timeout
+	Timeout time.Duration
+	// timeouts of specific routes, keyed by method and route template, e.g. "POST /orders/:id",
+	// routes with parameters are only matched when RouteTemplate is installed
+	Routes map[string]time.Duration
+	// returned on timeout, use a 503 error for service unavailable
+	Error *Error
+}
+
+func NewTimeoutConfig(timeout time.Duration) *TimeoutConfig {
+	return &TimeoutConfig{
+		Timeout: timeout,
+		Routes:  make(map[string]time.Duration),
+		Error:   ErrTimeout,
+	}
+}
+
+/*
+请求超时: 到期后取消c.Request.Context()并立即返回超时的统一格式响应。
+handler的输出先写入缓冲，按时完成时才写给客户端，超时后handler的写入全部丢弃，
+所以不适用于流式响应。handler应该通过请求的context及时退出，中间件会等待handler返回。
+*/
+func Timeout(config *TimeoutConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := config.Timeout
+		if len(config.Routes) > 0 {
+			if route, ok := routeKey(c); ok {
+				if t, ok := config.Routes[route]; ok {
+					timeout = t
+				}
+			}
+		}
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		tw := newTimeoutWriter(c.Writer)
+		c.Writer = tw
+		defer func() {
+			// also reached when the handler panics, the buffered output is dropped then
+			tw.stop()
+			c.Writer = tw.ResponseWriter
+		}()
+
+		done := make(chan struct{})
+		defer close(done)
+		timeoutErr := config.Error
+		if timeoutErr == nil {
+			timeoutErr = ErrTimeout
+		}
+		id := requestID(c)
+		go func() {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				if ctx.Err() == context.DeadlineExceeded {
+					tw.timeout(timeoutErr, id)
+				}
+			}
+		}()
+
+		c.Next()
+
+		if tw.finish() {
+			c.Abort()
+		}
+	}
+}
+
+/*
+timeoutWriter缓冲handler的输出，Header是独立的副本，
+避免超时后handler继续修改Header和超时响应并发。
+*/
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	mu     sync.Mutex
+	header http.Header
+	buf    bytes.Buffer
+	// gin's c.Status sets the status on the underlying writer, status is only used when WriteHeader is called
+	status      int
+	statusSet   bool
+	wroteHeader bool
+	timedOut    bool
+	finished    bool
+}
+
+func newTimeoutWriter(w gin.ResponseWriter) *timeoutWriter {
+	header := make(http.Header, len(w.Header()))
+	for k, v := range w.Header() {
+		header[k] = append([]string(nil), v...)
+	}
+	return &timeoutWriter{
+		ResponseWriter: w,
+		header:         header,
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if code > 0 && !w.wroteHeader && !w.timedOut {
+		w.status = code
+		w.statusSet = true
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.wroteHeader = true
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.buf.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.statusSet {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.wroteHeader {
+		return -1
+	}
+	return w.buf.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wroteHeader
+}
+
+// Flush does nothing, the output is sent when the handler returns
+func (w *timeoutWriter) Flush() {}
+
+func (w *timeoutWriter) timeout(e *Error, id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.finished {
+		return
+	}
+	w.timedOut = true
+
+	body, _ := json.Marshal(&Response{Code: e.Code, Message: e.Message, RequestID: id})
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.ResponseWriter.WriteHeader(e.Status)
+	w.ResponseWriter.Write(body)
+	w.ResponseWriter.Flush()
+}
+
+// finish copies the buffered output to the client, returns true if the request had timed out
+func (w *timeoutWriter) finish() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return true
+	}
+	w.finished = true
+
+	dst := w.ResponseWriter.Header()
+	for k, v := range w.header {
+		dst[k] = v
+	}
+	if w.statusSet {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	// a status set without writing is sent when the outer middlewares write
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeaderNow()
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	return false
+}
+
+// stop keeps the timer from writing once the handler returned
+func (w *timeoutWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished = true
+}
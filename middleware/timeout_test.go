@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTimeout(t *testing.T) {
+	config := NewTimeoutConfig(50 * time.Millisecond)
+	config.Routes["GET /slow/:id"] = time.Second
+
+	lateErr := make(chan error, 1)
+	r := gin.New()
+	r.Use(RouteTemplate(r), Timeout(config))
+	r.GET("/fast", func(c *gin.Context) {
+		c.Header("X-Order", "1")
+		c.String(http.StatusCreated, "created")
+	})
+	r.GET("/stuck", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		// give the timeout response time to be written
+		time.Sleep(10 * time.Millisecond)
+		_, err := c.Writer.WriteString("late")
+		lateErr <- err
+	})
+	r.GET("/slow/:id", func(c *gin.Context) {
+		time.Sleep(100 * time.Millisecond)
+		c.String(http.StatusOK, "slow")
+	})
+
+	w := doRequest(r, http.MethodGet, "/fast", nil)
+	if w.Code != http.StatusCreated || w.Body.String() != "created" || w.Header().Get("X-Order") != "1" {
+		t.Fatalf("unexpected response %d %s %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	start := time.Now()
+	w = doRequest(r, http.MethodGet, "/stuck", nil)
+	if time.Since(start) > time.Second {
+		t.Fatal("expect the request to be cancelled")
+	}
+	var resp Response
+	if w.Code != http.StatusGatewayTimeout || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expect 504 envelope, got %d %s", w.Code, w.Body.String())
+	}
+	if err := <-lateErr; err != http.ErrHandlerTimeout {
+		t.Fatalf("expect late write rejected, got %v", err)
+	}
+
+	if w = doRequest(r, http.MethodGet, "/slow/1", nil); w.Code != http.StatusOK || w.Body.String() != "slow" {
+		t.Fatalf("expect route timeout used, got %d %s", w.Code, w.Body.String())
+	}
+}
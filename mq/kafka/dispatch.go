@@ -0,0 +1,204 @@
+package kafka
+
+import (
+    "hash/fnv"
+    "sync"
+
+    "github.com/Shopify/sarama"
+)
+
+const (
+    OrderByPartition = "partition"
+    OrderByKey       = "key"
+)
+
+type offsetMarker interface {
+    MarkPartitionOffset(topic string, partition int32, offset int64, metadata string)
+}
+
+/*
+ 把消息分发给多个worker并发处理: 同一个partition(或同一个key)的消息总是交给同一个worker，保证顺序。
+ 处理中的消息数不超过maxInFlight，满了之后阻塞拉取消息。
+ 每个partition只提交连续处理完成的offset，前面的消息没处理完时后面已完成的消息不提交。
+*/
+type dispatcher struct {
+    consumer    *consumer
+    marker      offsetMarker
+    orderBy     string
+
+    workers     []chan job
+    slots       chan struct{}
+    offsets     *offsetTracker
+
+    wg          sync.WaitGroup
+}
+
+func newDispatcher(c *consumer, marker offsetMarker) *dispatcher {
+    workers := c.workers
+    if workers <= 0 {
+        workers = 1
+    }
+    maxInFlight := c.maxInFlight
+    if maxInFlight < workers {
+        maxInFlight = workers
+    }
+
+    d := &dispatcher{
+        consumer: c,
+        marker: marker,
+        orderBy: c.orderBy,
+        workers: make([]chan job, workers),
+        slots: make(chan struct{}, maxInFlight),
+        offsets: newOffsetTracker(),
+    }
+    for i := range d.workers {
+        // never blocks, the slots bound the messages queued
+        d.workers[i] = make(chan job, maxInFlight)
+        d.wg.Add(1)
+        go d.work(d.workers[i])
+    }
+    return d
+}
+
+// dispatch returns false if the consumer is closed while waiting for a free slot
+func (d *dispatcher) dispatch(msg *sarama.ConsumerMessage) bool {
+    select {
+    case d.slots <- struct{}{}:
+    case <-d.consumer.quit:
+        return false
+    }
+
+    generation := d.offsets.add(msg.Topic, msg.Partition, msg.Offset)
+    d.workers[d.worker(msg)] <- job{msg: msg, generation: generation}
+    return true
+}
+
+func (d *dispatcher) worker(msg *sarama.ConsumerMessage) int {
+    h := fnv.New32a()
+    if d.orderBy == OrderByKey && len(msg.Key) > 0 {
+        h.Write([]byte(msg.Topic))
+        h.Write(msg.Key)
+    } else {
+        h.Write([]byte(msg.Topic))
+        h.Write([]byte{byte(msg.Partition >> 24), byte(msg.Partition >> 16), byte(msg.Partition >> 8), byte(msg.Partition)})
+    }
+    return int(h.Sum32() % uint32(len(d.workers)))
+}
+
+func (d *dispatcher) work(jobs chan job) {
+    defer d.wg.Done()
+
+    for {
+        select {
+        case j := <-jobs:
+            // the offset of an unfinished message stays unmarked, it's consumed again after restart
+            if d.consumer.handle(j.msg) {
+                d.offsets.complete(j.msg.Topic, j.msg.Partition, j.msg.Offset, j.generation, d.marker)
+            }
+            <-d.slots
+        case <-d.consumer.quit:
+            return
+        }
+    }
+}
+
+// release forgets the partitions revoked by a rebalance, their pending messages are not marked any more
+func (d *dispatcher) release(partitions map[string][]int32) {
+    d.offsets.release(partitions)
+}
+
+// wait blocks until the workers return after the consumer is closed
+func (d *dispatcher) wait() {
+    d.wg.Wait()
+}
+
+// job is a dispatched message with the generation of its partition in the offsetTracker
+type job struct {
+    msg         *sarama.ConsumerMessage
+    generation  uint64
+}
+
+type topicPartition struct {
+    topic       string
+    partition   int32
+}
+
+// partitionOffsets holds the dispatched offsets of a partition in order
+type partitionOffsets struct {
+    // a new generation starts every time the partition is claimed again after a release
+    generation  uint64
+    pending     []int64
+    done        map[int64]bool
+}
+
+type offsetTracker struct {
+    mu          sync.Mutex
+    partitions  map[topicPartition]*partitionOffsets
+    generations uint64
+}
+
+func newOffsetTracker() *offsetTracker {
+    return &offsetTracker{partitions: make(map[topicPartition]*partitionOffsets)}
+}
+
+// add returns the generation of the partition, which has to be passed to complete
+func (t *offsetTracker) add(topic string, partition int32, offset int64) uint64 {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    tp := topicPartition{topic, partition}
+    p, ok := t.partitions[tp]
+    if !ok {
+        t.generations++
+        p = &partitionOffsets{generation: t.generations, done: make(map[int64]bool)}
+        t.partitions[tp] = p
+    }
+    p.pending = append(p.pending, offset)
+    return p.generation
+}
+
+/*
+ 标记该partition中之前的消息都已处理完的最大offset，在锁内标记保证offset不会回退。
+ 不在当前generation中等待的offset(partition被release后仍在处理的旧消息)被忽略，
+ 否则重新分配后它可能让还没处理的同一个offset被提交。
+*/
+func (t *offsetTracker) complete(topic string, partition int32, offset int64, generation uint64, marker offsetMarker) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    p, ok := t.partitions[topicPartition{topic, partition}]
+    if !ok || p.generation != generation || p.done[offset] || !p.isPending(offset) {
+        return
+    }
+    p.done[offset] = true
+
+    last := int64(-1)
+    for len(p.pending) > 0 && p.done[p.pending[0]] {
+        last = p.pending[0]
+        delete(p.done, last)
+        p.pending = p.pending[1:]
+    }
+    if last >= 0 {
+        marker.MarkPartitionOffset(topic, partition, last, "")
+    }
+}
+
+func (p *partitionOffsets) isPending(offset int64) bool {
+    for _, pending := range p.pending {
+        if pending == offset {
+            return true
+        }
+    }
+    return false
+}
+
+func (t *offsetTracker) release(partitions map[string][]int32) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    for topic, ids := range partitions {
+        for _, id := range ids {
+            delete(t.partitions, topicPartition{topic, id})
+        }
+    }
+}
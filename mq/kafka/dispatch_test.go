@@ -0,0 +1,166 @@
+package kafka
+
+import (
+    "reflect"
+    "strconv"
+    "sync"
+    "testing"
+
+    "github.com/Shopify/sarama"
+)
+
+// fakeMarker records the marked offsets by partition
+type fakeMarker struct {
+    mu      sync.Mutex
+    marks   map[int32][]int64
+}
+
+func newFakeMarker() *fakeMarker {
+    return &fakeMarker{marks: make(map[int32][]int64)}
+}
+
+func (m *fakeMarker) MarkPartitionOffset(topic string, partition int32, offset int64, metadata string) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.marks[partition] = append(m.marks[partition], offset)
+}
+
+func TestOffsetTracker(t *testing.T) {
+    type step struct {
+        op      string
+        offset  int64
+        // complete with the generation from before the last release
+        stale   bool
+    }
+    add := func(offset int64) step { return step{op: "add", offset: offset} }
+    complete := func(offset int64) step { return step{op: "complete", offset: offset} }
+    release := step{op: "release"}
+
+    cases := []struct {
+        name    string
+        steps   []step
+        marks   []int64
+    }{
+        {"in order", []step{add(1), add(2), add(3), complete(1), complete(2), complete(3)}, []int64{1, 2, 3}},
+        {"out of order", []step{add(1), add(2), add(3), complete(3), complete(2), complete(1)}, []int64{3}},
+        {"gap", []step{add(1), add(2), add(3), complete(1), complete(3)}, []int64{1}},
+        {"not dispatched", []step{add(1), add(2), complete(5), complete(1)}, []int64{1}},
+        {"twice", []step{add(1), add(2), complete(2), complete(2), complete(1)}, []int64{2}},
+        {"released", []step{add(1), release, complete(1)}, nil},
+        {"stale after re-claim", []step{
+            add(10), add(11), release, add(10), add(11),
+            {op: "complete", offset: 11, stale: true}, complete(10),
+        }, []int64{10}},
+        {"stale of another offset", []step{
+            add(10), add(11), release, add(11), add(12),
+            {op: "complete", offset: 10, stale: true}, complete(11), complete(12),
+        }, []int64{11, 12}},
+    }
+    for _, tc := range cases {
+        tracker := newOffsetTracker()
+        marker := newFakeMarker()
+        var generation, previous uint64
+
+        for _, s := range tc.steps {
+            switch s.op {
+            case "add":
+                generation = tracker.add("orders", 0, s.offset)
+            case "release":
+                tracker.release(map[string][]int32{"orders": {0}})
+                previous = generation
+            case "complete":
+                gen := generation
+                if s.stale {
+                    gen = previous
+                }
+                tracker.complete("orders", 0, s.offset, gen, marker)
+            }
+        }
+        if !reflect.DeepEqual(marker.marks[0], tc.marks) {
+            t.Errorf("%s: expect marks %v, got %v", tc.name, tc.marks, marker.marks[0])
+        }
+    }
+}
+
+func TestDispatcherWorker(t *testing.T) {
+    msg := func(partition int32, key string) *sarama.ConsumerMessage {
+        m := &sarama.ConsumerMessage{Topic: "orders", Partition: partition}
+        if key != "" {
+            m.Key = []byte(key)
+        }
+        return m
+    }
+
+    cases := []struct {
+        name    string
+        orderBy string
+        a, b    *sarama.ConsumerMessage
+    }{
+        {"same partition", OrderByPartition, msg(3, "k1"), msg(3, "k2")},
+        {"same key", OrderByKey, msg(1, "k1"), msg(2, "k1")},
+        {"no key falls back to partition", OrderByKey, msg(5, ""), msg(5, "")},
+    }
+    for _, tc := range cases {
+        d := &dispatcher{orderBy: tc.orderBy, workers: make([]chan job, 8)}
+        if d.worker(tc.a) != d.worker(tc.b) {
+            t.Errorf("%s: expect the same worker", tc.name)
+        }
+    }
+
+    // keys are spread over the workers
+    d := &dispatcher{orderBy: OrderByKey, workers: make([]chan job, 8)}
+    used := make(map[int]bool)
+    for i := 0; i < 100; i++ {
+        used[d.worker(msg(0, strconv.Itoa(i)))] = true
+    }
+    if len(used) < 2 {
+        t.Fatalf("expect keys on several workers, got %v", used)
+    }
+}
+
+func TestDispatcher(t *testing.T) {
+    const keys, perKey = 4, 25
+
+    c := newTestConsumer(nil)
+    c.workers = 4
+    c.orderBy = OrderByKey
+    c.maxInFlight = 8
+
+    var mu sync.Mutex
+    var processed sync.WaitGroup
+    seen := make(map[string][]int64)
+    c.handlers["orders"] = func(msg *Message) error {
+        mu.Lock()
+        seen[string(msg.Key)] = append(seen[string(msg.Key)], msg.Offset)
+        mu.Unlock()
+        processed.Done()
+        return nil
+    }
+
+    marker := newFakeMarker()
+    d := newDispatcher(c, marker)
+    processed.Add(keys * perKey)
+    for i := 0; i < keys*perKey; i++ {
+        d.dispatch(&sarama.ConsumerMessage{Topic: "orders", Offset: int64(i), Key: []byte(strconv.Itoa(i % keys))})
+    }
+    processed.Wait()
+    close(c.quit)
+    d.wait()
+
+    for key, offsets := range seen {
+        for i := 1; i < len(offsets); i++ {
+            if offsets[i] < offsets[i-1] {
+                t.Fatalf("key %s processed out of order: %v", key, offsets)
+            }
+        }
+    }
+    marks := marker.marks[0]
+    if len(marks) == 0 || marks[len(marks)-1] != keys*perKey-1 {
+        t.Fatalf("expect the last offset marked, got %v", marks)
+    }
+    for i := 1; i < len(marks); i++ {
+        if marks[i] <= marks[i-1] {
+            t.Fatalf("expect marks to move forward, got %v", marks)
+        }
+    }
+}
@@ -3,28 +3,66 @@ package kafka
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "github.com/Shopify/sarama"
     cluster "github.com/bsm/sarama-cluster"
     "log"
+    "regexp"
+    "strconv"
     "sync"
     "time"
+
+    "basego/logger"
 )
 
 var cli *client
 
+// Message is a consumed message with its metadata, Headers is only filled with kafka 0.11.0 or later
+type Message struct {
+    Topic       string
+    Partition   int32
+    Offset      int64
+    Key         []byte
+    Value       []byte
+    Headers     map[string][]byte
+    Timestamp   time.Time
+}
+
+// Handler processes the messages of a topic, the offset is marked only when it returns nil
+type Handler func(msg *Message) error
+
 type Config struct {
     Consumer    *consumerConfig  `yaml:"consumer"`
     Producer    *producerConfig  `yaml:"producer"`
 }
 
 type consumerConfig struct {
-    Enable      bool        `yaml:"enable"`
-    Group       string      `yaml:"group"`
-    Hosts       []string    `yaml:"hosts"`
-    Topic       string      `yaml:"topic"`
-
-    wg          *sync.WaitGroup
+    Enable          bool            `yaml:"enable"`
+    Group           string          `yaml:"group"`
+    Hosts           []string        `yaml:"hosts"`
+    Topic           string          `yaml:"topic"`
+    // consumed in addition to Topic
+    Topics          []string        `yaml:"topics"`
+    // regex, every topic matching it is consumed as well, e.g. ^orders\..*
+    TopicPattern    string          `yaml:"topic_pattern"`
+    // kafka version of the brokers, e.g. 2.1.0, message headers need 0.11.0 or later
+    Version         string          `yaml:"version"`
+
+    // a message failing the processor is retried Retry times, with the backoff doubled from RetryBackoff up to MaxRetryBackoff
+    Retry           int             `yaml:"retry"`
+    RetryBackoff    time.Duration   `yaml:"retry_backoff"`
+    MaxRetryBackoff time.Duration   `yaml:"max_retry_backoff"`
+    // topic receiving the messages still failing after the retries, they are dropped if empty
+    DeadLetterTopic string          `yaml:"dead_letter_topic"`
+
+    // messages are processed by Workers goroutines, in order per partition or per key (OrderBy partition|key)
+    Workers         int             `yaml:"workers"`
+    OrderBy         string          `yaml:"order_by"`
+    // max messages being processed or waiting for a worker
+    MaxInFlight     int             `yaml:"max_in_flight"`
+
+    wg              *sync.WaitGroup
 }
 
 type producerConfig struct {
@@ -33,6 +71,10 @@ type producerConfig struct {
     Topic       string      `yaml:"topic"`
     PoolSize    int         `yaml:"pool_size"`
     Retry       int         `yaml:"retry"`
+    // 0 no ack, 1 leader only, -1 every in-sync replica
+    RequiredAcks    int             `yaml:"required_acks"`
+    // max time Put and Put2Topic wait for room in the send queue
+    SendTimeout     time.Duration   `yaml:"send_timeout"`
 
     wg          *sync.WaitGroup
 }
@@ -43,11 +85,19 @@ func NewConfig() *Config {
             Enable: false,
             Topic: "",
             Hosts: nil,
+            Retry: 3,
+            RetryBackoff: time.Second,
+            MaxRetryBackoff: 30 * time.Second,
+            Workers: 1,
+            OrderBy: OrderByPartition,
+            MaxInFlight: 256,
         },
         Producer: &producerConfig{
             Enable: false,
             PoolSize: 300,
             Retry: 3,
+            RequiredAcks: 1,
+            SendTimeout: 5 * time.Second,
         },
     }
 }
@@ -64,6 +114,10 @@ func NewQueueClient() *client {
     }
 }
 
+/*
+ 初始化消息队列，Producer立即可用。Consumer只做准备，注册处理函数后调用Start开始消费，
+ 避免在注册之前到达的消息没有处理函数。
+*/
 func Init(ctx context.Context, config *Config) error {
     fmt.Println("queue config: ", config.Consumer, config.Producer)
     wg := ctx.Value("WaitGroup").(*sync.WaitGroup)
@@ -91,28 +145,97 @@ func Init(ctx context.Context, config *Config) error {
     return nil
 }
 
-func RegisterConsumeProcessor(processor func([]byte)) error {
+/*
+ 注册消息处理函数，处理函数返回nil后才提交该消息的offset(at-least-once)，
+ 返回错误时按配置重试，重试后仍然失败的消息发送到死信队列。
+ processor处理没有通过RegisterHandler注册处理函数的topic。
+*/
+func RegisterConsumeProcessor(processor func([]byte) error) error {
     if cli.consumer == nil {
         return fmt.Errorf("未启用Consumer，无法注册处理函数")
     }
-    cli.consumer.processor = processor
+    cli.consumer.mu.Lock()
+    cli.consumer.fallback = func(msg *Message) error {
+        return processor(msg.Value)
+    }
+    cli.consumer.mu.Unlock()
+
+    return nil
+}
+
+// RegisterHandler registers the handler of topic, topic must be configured or match the topic pattern
+func RegisterHandler(topic string, handler Handler) error {
+    if cli.consumer == nil {
+        return fmt.Errorf("未启用Consumer，无法注册处理函数")
+    }
+    if !cli.consumer.subscribed(topic) {
+        return fmt.Errorf("未订阅topic %s", topic)
+    }
+    cli.consumer.mu.Lock()
+    cli.consumer.handlers[topic] = handler
+    cli.consumer.mu.Unlock()
 
     return nil
 }
 
+// Start begins consuming, it must be called once after the handlers are registered
+func Start() error {
+    if cli == nil || cli.consumer == nil {
+        return fmt.Errorf("未启用Consumer，无法开始消费")
+    }
+    return cli.consumer.start()
+}
+
 func (c *client) runConsumer(config *consumerConfig) error {
     consumer := &consumer{
         group: config.Group,
         hosts: config.Hosts,
-        topics: []string{config.Topic},
+        topics: make([]string, 0, len(config.Topics) + 1),
+        version: sarama.NewConfig().Version,
+        handlers: make(map[string]Handler),
+
+        retry: config.Retry,
+        retryBackoff: config.RetryBackoff,
+        maxRetryBackoff: config.MaxRetryBackoff,
+        deadLetterTopic: config.DeadLetterTopic,
+
+        workers: config.Workers,
+        orderBy: config.OrderBy,
+        maxInFlight: config.MaxInFlight,
 
         wg: config.wg,
         quit: make(chan bool),
     }
+    seen := make(map[string]bool)
+    for _, topic := range append([]string{config.Topic}, config.Topics...) {
+        if topic != "" && !seen[topic] {
+            seen[topic] = true
+            consumer.topics = append(consumer.topics, topic)
+        }
+    }
+    if config.TopicPattern != "" {
+        pattern, err := regexp.Compile(config.TopicPattern)
+        if err != nil {
+            return err
+        }
+        consumer.pattern = pattern
+    }
+    if len(consumer.topics) == 0 && consumer.pattern == nil {
+        return fmt.Errorf("未配置Consumer的topic")
+    }
+    if consumer.orderBy != "" && consumer.orderBy != OrderByPartition && consumer.orderBy != OrderByKey {
+        return fmt.Errorf("不支持的order_by %s", consumer.orderBy)
+    }
 
-    if err := consumer.generateConsumerCluster(); err != nil {
-        return err
+    if config.Version != "" {
+        version, err := sarama.ParseKafkaVersion(config.Version)
+        if err != nil {
+            return err
+        }
+        consumer.version = version
     }
+
+    // consuming starts with Start, once the handlers are registered
     c.consumer = consumer
 
     return nil
@@ -123,10 +246,13 @@ func (c *client) runProducer(config *producerConfig) error {
         hosts: config.Hosts,
         topic: config.Topic,
         retry: config.Retry,
+        requiredAcks: sarama.RequiredAcks(config.RequiredAcks),
+        sendTimeout: config.SendTimeout,
         inputChan: make(chan *sarama.ProducerMessage, config.PoolSize),
 
         wg: config.wg,
         quit: make(chan bool),
+        closed: make(chan struct{}),
     }
 
     if err := producer.generateProducer(); err != nil {
@@ -147,45 +273,78 @@ func (c *client) Close() {
 }
 
 type producer struct {
-    hosts       []string
-    topic       string
-    retry       int
-    inputChan   chan *sarama.ProducerMessage
-
+    hosts           []string
+    topic           string
+    retry           int
+    requiredAcks    sarama.RequiredAcks
+    sendTimeout     time.Duration
+    inputChan       chan *sarama.ProducerMessage
+
+    // send holds it for reading while queueing, so that no message is queued after the queue is drained
+    mu          sync.RWMutex
+    stopped     bool
     quit        chan bool
+    // closed after every message got its result
+    closed      chan struct{}
 
     wg      *sync.WaitGroup
 }
 
+var ErrProducerClosed = errors.New("kafka producer is closed")
+
+// Callback receives the partition and offset of a message written by the broker, or the error
+type Callback func(partition int32, offset int64, err error)
 
 func (p *producer) generateProducer() error {
     pconf := sarama.NewConfig()
     pconf.Producer.Retry.Max = p.retry
     pconf.Producer.Retry.Backoff = 3 * time.Second
-    pconf.Producer.RequiredAcks = 1
+    pconf.Producer.RequiredAcks = p.requiredAcks
+    pconf.Producer.Return.Successes = true
 
     producer, err := sarama.NewAsyncProducer(p.hosts, pconf)
     if err != nil {
         return err
     }
+    p.start(producer)
+
+    return nil
+}
+
+// start forwards the queued messages to prod and their results to the callbacks until quit
+func (p *producer) start(producer sarama.AsyncProducer) {
     p.wg.Add(1)
 
+    // results, returns once AsyncClose flushed every message and closed both channels
     go func(prod sarama.AsyncProducer) {
-        for {
+        successes, errs := prod.Successes(), prod.Errors()
+        for successes != nil || errs != nil {
             select {
-            case err := <-prod.Errors():
-                if err != nil {
-                    log.Printf("Input Queue Error, %s\n", err.Error())
+            case perr, ok := <-errs:
+                if !ok {
+                    errs = nil
+                    continue
                 }
-            case success := <-prod.Successes():
-                if success != nil {
-                    bt, _ := success.Value.Encode()
-                    log.Printf("Input Queue Success, %s\n", string(bt))
+                log.Printf("Input Queue Error, %s\n", perr.Error())
+                done(perr.Msg, perr.Err)
+            case success, ok := <-successes:
+                if !ok {
+                    successes = nil
+                    continue
                 }
-            case <-p.quit:
-                return
+                // message bodies may hold personal data, only the position is logged
+                logger.Debug("kafka message sent", "", map[string]interface{}{
+                    "topic": success.Topic,
+                    "partition": success.Partition,
+                    "offset": success.Offset,
+                })
+                done(success, nil)
             }
         }
+
+        close(p.closed)
+        p.wg.Done()
+        log.Printf("Message Queue Producer was closed!\n")
     }(producer)
 
     go func(prod sarama.AsyncProducer) {
@@ -195,16 +354,46 @@ func (p *producer) generateProducer() error {
                 prod.Input() <- msg
             case <-p.quit:
                 log.Printf("Closing Message Queue Producer... \n")
+                // wait for the sends in progress, later ones see stopped
+                p.mu.Lock()
+                p.stopped = true
+                p.mu.Unlock()
+                // the queued messages are not sent any more
+                for len(p.inputChan) > 0 {
+                    done(<-p.inputChan, ErrProducerClosed)
+                }
                 prod.AsyncClose()
-
-                p.wg.Done()
-                log.Printf("Message Queue Producer was closed!\n")
                 return
             }
         }
     }(producer)
+}
 
-    return nil
+func done(msg *sarama.ProducerMessage, err error) {
+    if msg == nil {
+        return
+    }
+    if callback, ok := msg.Metadata.(Callback); ok && callback != nil {
+        callback(msg.Partition, msg.Offset, err)
+    }
+}
+
+// send queues message, it fails if ctx is done before there is room in the queue
+func (p *producer) send(ctx context.Context, message *sarama.ProducerMessage) error {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    if p.stopped {
+        return ErrProducerClosed
+    }
+
+    select {
+    case p.inputChan <- message:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-p.quit:
+        return ErrProducerClosed
+    }
 }
 
 func (p *producer) close() {
@@ -219,8 +408,24 @@ type consumer struct {
     group   string
     hosts   []string
     topics  []string
+    pattern *regexp.Regexp
+    version sarama.KafkaVersion
 
-    processor func([]byte)
+    mu          sync.RWMutex
+    handlers    map[string]Handler
+    // handles the topics without a handler
+    fallback    Handler
+    started     bool
+
+    retry           int
+    retryBackoff    time.Duration
+    maxRetryBackoff time.Duration
+    deadLetterTopic string
+    deadLetter      sarama.SyncProducer
+
+    workers         int
+    orderBy         string
+    maxInFlight     int
 
     quit    chan bool
 
@@ -232,6 +437,10 @@ func (c *consumer) generateConsumer() error {
     qconf := sarama.NewConfig()
     qconf.Consumer.Return.Errors = true
 
+    if len(c.topics) == 0 {
+        return fmt.Errorf("未配置Consumer的topic")
+    }
+
     master, err := sarama.NewConsumer(c.hosts, qconf)
     if err != nil {
         return err
@@ -250,11 +459,7 @@ func (c *consumer) generateConsumer() error {
                 fmt.Printf("consumer return error: %s\n", err.Error())
             case msg := <-consumer.Messages():
                 log.Printf("+++ [Q]Record consume message: Topic: %s, Offset: %d, Partition: %d\n", msg.Topic, msg.Offset, msg.Partition)
-                if c.processor == nil {
-                    log.Printf("+++ ERROR not register Consumer Processor!!! \n")
-                    continue
-                }
-                c.processor(msg.Value)
+                c.handle(msg)
             case <-c.quit:
                 log.Println("Closing Message Queue Consumer...")
                 consumer.AsyncClose()
@@ -268,14 +473,33 @@ func (c *consumer) generateConsumer() error {
 
 func (c *consumer) generateConsumerCluster() error {
     conf := cluster.NewConfig()
+    conf.Version = c.version
     conf.Consumer.Return.Errors = true
     conf.Group.Return.Notifications = true
+    conf.Group.Topics.Whitelist = c.pattern
+
+    if c.deadLetterTopic != "" {
+        pconf := sarama.NewConfig()
+        pconf.Version = c.version
+        pconf.Producer.RequiredAcks = sarama.WaitForAll
+        pconf.Producer.Return.Successes = true
+
+        deadLetter, err := sarama.NewSyncProducer(c.hosts, pconf)
+        if err != nil {
+            return err
+        }
+        c.deadLetter = deadLetter
+    }
 
     consumer, err := cluster.NewConsumer(c.hosts, c.group, c.topics, conf)
     if err != nil {
+        if c.deadLetter != nil {
+            _ = c.deadLetter.Close()
+        }
         return err
     }
     c.wg.Add(1)
+    d := newDispatcher(c, consumer)
 
     // Listen
     go func() {
@@ -285,16 +509,20 @@ func (c *consumer) generateConsumerCluster() error {
                 log.Printf("+++ [Q]Consumer return error: %s\n", err.Error())
             case ntf := <-consumer.Notifications():
                 log.Printf("+++ [Q]Rebalanced: %+v\n", ntf)
+                if ntf != nil {
+                    d.release(ntf.Released)
+                }
             case msg := <-consumer.Messages():
                 log.Printf("+++ [Q]Record consumed message meta: Topic: %s, Offset: %d, Partition: %d\n", msg.Topic, msg.Offset, msg.Partition)
-                if c.processor == nil {
-                    log.Printf("+++ [Q]ERROR not register Consumer Processor!!! \n")
-                    continue
-                }
-                c.processor(msg.Value)
+                d.dispatch(msg)
             case <-c.quit:
                 log.Println("Closing Message Queue Consumer...")
+                // wait for the workers so that the last offsets are marked, Close commits them
+                d.wait()
                 _ = consumer.Close()
+                if c.deadLetter != nil {
+                    _ = c.deadLetter.Close()
+                }
 
                 c.wg.Done()
                 log.Println("Message Queue Consumer was closed!")
@@ -306,6 +534,24 @@ func (c *consumer) generateConsumerCluster() error {
     return nil
 }
 
+func (c *consumer) start() error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.started {
+        return fmt.Errorf("Consumer已经启动")
+    }
+    if len(c.handlers) == 0 && c.fallback == nil {
+        return fmt.Errorf("未注册处理函数，无法开始消费")
+    }
+    if err := c.generateConsumerCluster(); err != nil {
+        return err
+    }
+    c.started = true
+
+    return nil
+}
+
 func (c *consumer) close() {
     if c.quit == nil {
         return
@@ -313,8 +559,196 @@ func (c *consumer) close() {
     close(c.quit)
 }
 
-//
+/*
+ 处理一条消息: 失败时按退避时间重试，重试后仍然失败的消息发送到死信队列，
+ 发送死信失败时一直重试。没有处理函数的消息不处理也不提交，等待注册处理函数。
+ 返回false表示consumer在消息处理完之前被关闭，不能提交offset。
+*/
+func (c *consumer) handle(msg *sarama.ConsumerMessage) bool {
+    handler := c.waitHandler(msg)
+    if handler == nil {
+        return false
+    }
+
+    backoff := c.retryBackoff
+    var err error
+    for attempt := 0; ; attempt++ {
+        if err = process(handler, msg); err == nil {
+            return true
+        }
+        log.Printf("+++ [Q]Process message failed, Topic: %s, Offset: %d, Partition: %d, Attempt: %d, %s\n",
+            msg.Topic, msg.Offset, msg.Partition, attempt+1, err.Error())
+        if attempt >= c.retry {
+            break
+        }
+        if !c.sleep(backoff) {
+            return false
+        }
+        backoff = c.nextBackoff(backoff)
+    }
+
+    if c.deadLetter == nil {
+        log.Printf("+++ [Q]ERROR drop message, Topic: %s, Offset: %d, Partition: %d\n", msg.Topic, msg.Offset, msg.Partition)
+        return true
+    }
+    backoff = c.retryBackoff
+    for {
+        dlqErr := c.sendDeadLetter(msg, err)
+        if dlqErr == nil {
+            return true
+        }
+        log.Printf("+++ [Q]Send to dead letter topic %s failed, %s\n", c.deadLetterTopic, dlqErr.Error())
+        if !c.sleep(backoff) {
+            return false
+        }
+        backoff = c.nextBackoff(backoff)
+    }
+}
+
+func (c *consumer) subscribed(topic string) bool {
+    for _, t := range c.topics {
+        if t == topic {
+            return true
+        }
+    }
+    return c.pattern != nil && c.pattern.MatchString(topic)
+}
+
+/*
+ 返回msg的处理函数。没有时消息既不能处理也不能提交(提交就丢失了)，
+ 记录错误并按退避时间等待注册，同一分区(或key)后续的消息也随之等待。consumer关闭时返回nil。
+*/
+func (c *consumer) waitHandler(msg *sarama.ConsumerMessage) Handler {
+    backoff := c.retryBackoff
+    for {
+        if handler := c.getHandler(msg.Topic); handler != nil {
+            return handler
+        }
+        logger.Error("no kafka handler, message is held", msg.Topic, map[string]interface{}{
+            "partition": msg.Partition,
+            "offset": msg.Offset,
+        })
+        if !c.sleep(backoff) {
+            return nil
+        }
+        backoff = c.nextBackoff(backoff)
+    }
+}
+
+func (c *consumer) getHandler(topic string) Handler {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+
+    if handler, ok := c.handlers[topic]; ok {
+        return handler
+    }
+    return c.fallback
+}
+
+// process turns a panic of the handler into an error so that the message is retried
+func process(handler Handler, msg *sarama.ConsumerMessage) (err error) {
+    defer func() {
+        if rec := recover(); rec != nil {
+            err = fmt.Errorf("processor panic: %v", rec)
+        }
+    }()
+    return handler(newMessage(msg))
+}
+
+func newMessage(msg *sarama.ConsumerMessage) *Message {
+    m := &Message{
+        Topic: msg.Topic,
+        Partition: msg.Partition,
+        Offset: msg.Offset,
+        Key: msg.Key,
+        Value: msg.Value,
+        Timestamp: msg.Timestamp,
+    }
+    if len(msg.Headers) > 0 {
+        m.Headers = make(map[string][]byte, len(msg.Headers))
+        for _, h := range msg.Headers {
+            m.Headers[string(h.Key)] = h.Value
+        }
+    }
+    return m
+}
+
+func (c *consumer) sendDeadLetter(msg *sarama.ConsumerMessage, cause error) error {
+    message := &sarama.ProducerMessage{
+        Topic: c.deadLetterTopic,
+        Value: sarama.ByteEncoder(msg.Value),
+    }
+    if msg.Key != nil {
+        message.Key = sarama.ByteEncoder(msg.Key)
+    }
+    if c.version.IsAtLeast(sarama.V0_11_0_0) {
+        for _, h := range msg.Headers {
+            message.Headers = append(message.Headers, *h)
+        }
+        message.Headers = append(message.Headers,
+            sarama.RecordHeader{Key: []byte("x-original-topic"), Value: []byte(msg.Topic)},
+            sarama.RecordHeader{Key: []byte("x-original-partition"), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+            sarama.RecordHeader{Key: []byte("x-original-offset"), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+            sarama.RecordHeader{Key: []byte("x-error"), Value: []byte(cause.Error())},
+        )
+    }
+
+    _, _, err := c.deadLetter.SendMessage(message)
+    return err
+}
+
+func (c *consumer) nextBackoff(backoff time.Duration) time.Duration {
+    backoff *= 2
+    if c.maxRetryBackoff > 0 && backoff > c.maxRetryBackoff {
+        backoff = c.maxRetryBackoff
+    }
+    return backoff
+}
+
+// sleep returns false if the consumer is closed in the meantime
+func (c *consumer) sleep(d time.Duration) bool {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return true
+    case <-c.quit:
+        return false
+    }
+}
+
+// Put2Topic queues msg as json without waiting for the result, it fails if the queue stays full for SendTimeout
 func Put2Topic(topic string, msg interface{}) error {
+    if cli == nil || cli.producer == nil {
+        return fmt.Errorf("未启用Producer")
+    }
+    ctx := context.Background()
+    if cli.producer.sendTimeout > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, cli.producer.sendTimeout)
+        defer cancel()
+    }
+
+    return PutAsync(ctx, topic, "", msg, nil)
+}
+
+func Put(message interface{}) error {
+    if cli == nil || cli.producer == nil {
+        return fmt.Errorf("未启用Producer")
+    }
+    return Put2Topic(cli.producer.topic, message)
+}
+
+/*
+ 异步发送json格式的消息，ctx用于等待发送队列，消息进入队列后不再受ctx影响。
+ callback在broker确认写入或发送失败后调用(在producer的goroutine中，不能阻塞)，可以为nil。
+ key不为空时相同key的消息写入同一个partition。
+*/
+func PutAsync(ctx context.Context, topic string, key string, msg interface{}, callback Callback) error {
+    if cli == nil || cli.producer == nil {
+        return fmt.Errorf("未启用Producer")
+    }
     msgBytes, err := json.Marshal(msg)
     if err != nil {
         return err
@@ -322,14 +756,44 @@ func Put2Topic(topic string, msg interface{}) error {
     message := &sarama.ProducerMessage{
         Topic: topic,
         Value: sarama.ByteEncoder(msgBytes),
+        Metadata: callback,
+    }
+    if key != "" {
+        message.Key = sarama.StringEncoder(key)
     }
 
-    cli.producer.inputChan <- message
-
-    return nil
+    return cli.producer.send(ctx, message)
 }
 
-func Put(message interface{}) error {
-    return Put2Topic(cli.producer.topic, message)
+// PutSync sends msg as json and waits until the broker acks it (see required_acks), returning where it was written
+func PutSync(ctx context.Context, topic string, key string, msg interface{}) (int32, int64, error) {
+    type result struct {
+        partition   int32
+        offset      int64
+        err         error
+    }
+    ch := make(chan result, 1)
+    callback := func(partition int32, offset int64, err error) {
+        ch <- result{partition, offset, err}
+    }
+
+    if err := PutAsync(ctx, topic, key, msg, callback); err != nil {
+        return 0, 0, err
+    }
+    select {
+    case ret := <-ch:
+        return ret.partition, ret.offset, ret.err
+    case <-ctx.Done():
+        // the message may still be written
+        return 0, 0, ctx.Err()
+    case <-cli.producer.closed:
+        // the result may have arrived just before closing
+        select {
+        case ret := <-ch:
+            return ret.partition, ret.offset, ret.err
+        default:
+            return 0, 0, ErrProducerClosed
+        }
+    }
 }
 
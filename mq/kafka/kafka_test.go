@@ -0,0 +1,318 @@
+package kafka
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Shopify/sarama"
+    "github.com/Shopify/sarama/mocks"
+)
+
+// fakeDeadLetter records the messages sent to the dead letter topic, the first sends fail as many times as failures
+type fakeDeadLetter struct {
+    failures    int
+    sent        []*sarama.ProducerMessage
+}
+
+func (p *fakeDeadLetter) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+    if p.failures > 0 {
+        p.failures--
+        return 0, 0, errors.New("broker unavailable")
+    }
+    p.sent = append(p.sent, msg)
+    return 0, int64(len(p.sent)), nil
+}
+
+func (p *fakeDeadLetter) SendMessages(msgs []*sarama.ProducerMessage) error {
+    for _, msg := range msgs {
+        if _, _, err := p.SendMessage(msg); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (p *fakeDeadLetter) Close() error {
+    return nil
+}
+
+func newTestConsumer(deadLetter sarama.SyncProducer) *consumer {
+    c := &consumer{
+        topics: []string{"orders"},
+        version: sarama.V2_1_0_0,
+        handlers: make(map[string]Handler),
+        retry: 2,
+        retryBackoff: time.Millisecond,
+        maxRetryBackoff: 2 * time.Millisecond,
+        quit: make(chan bool),
+    }
+    if deadLetter != nil {
+        c.deadLetterTopic = "orders.dlq"
+        c.deadLetter = deadLetter
+    }
+    return c
+}
+
+func header(msg *sarama.ProducerMessage, key string) string {
+    for _, h := range msg.Headers {
+        if string(h.Key) == key {
+            return string(h.Value)
+        }
+    }
+    return ""
+}
+
+func TestConsumerHandle(t *testing.T) {
+    errFail := errors.New("fail")
+
+    cases := []struct {
+        name        string
+        // the handler fails the first n calls, -1 for always
+        fails       int
+        panics      bool
+        dlqFailures int
+        noDLQ       bool
+        calls       int
+        deadLetters int
+        cause       string
+    }{
+        {name: "success", fails: 0, calls: 1},
+        {name: "retried", fails: 2, calls: 3},
+        {name: "dead letter", fails: -1, calls: 3, deadLetters: 1, cause: "fail"},
+        {name: "panic", fails: -1, panics: true, calls: 3, deadLetters: 1, cause: "processor panic: fail"},
+        {name: "dead letter retried", fails: -1, dlqFailures: 2, calls: 3, deadLetters: 1, cause: "fail"},
+        {name: "dropped", fails: -1, noDLQ: true, calls: 3},
+    }
+    for _, tc := range cases {
+        dlq := &fakeDeadLetter{failures: tc.dlqFailures}
+        c := newTestConsumer(dlq)
+        if tc.noDLQ {
+            c = newTestConsumer(nil)
+        }
+
+        calls := 0
+        c.handlers["orders"] = func(msg *Message) error {
+            calls++
+            if tc.fails >= 0 && calls > tc.fails {
+                return nil
+            }
+            if tc.panics {
+                panic(errFail)
+            }
+            return errFail
+        }
+
+        msg := &sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 7, Key: []byte("k"), Value: []byte("v")}
+        if !c.handle(msg) {
+            t.Errorf("%s: expect the message to be handled", tc.name)
+            continue
+        }
+        if calls != tc.calls {
+            t.Errorf("%s: expect %d calls, got %d", tc.name, tc.calls, calls)
+        }
+        if len(dlq.sent) != tc.deadLetters {
+            t.Errorf("%s: expect %d dead letters, got %d", tc.name, tc.deadLetters, len(dlq.sent))
+            continue
+        }
+        if tc.deadLetters == 0 {
+            continue
+        }
+        sent := dlq.sent[0]
+        if sent.Topic != "orders.dlq" || header(sent, "x-original-topic") != "orders" ||
+            header(sent, "x-original-offset") != "7" || !strings.HasPrefix(header(sent, "x-error"), tc.cause) {
+            t.Errorf("%s: unexpected dead letter %+v", tc.name, sent)
+        }
+    }
+}
+
+func TestConsumerHandleClosed(t *testing.T) {
+    c := newTestConsumer(nil)
+    c.retryBackoff = time.Hour
+    c.handlers["orders"] = func(msg *Message) error {
+        return errors.New("fail")
+    }
+    close(c.quit)
+
+    if c.handle(&sarama.ConsumerMessage{Topic: "orders"}) {
+        t.Fatal("expect a consumer closed while retrying not to mark the message")
+    }
+}
+
+func TestConsumerHandleNoHandler(t *testing.T) {
+    dlq := &fakeDeadLetter{}
+    c := newTestConsumer(dlq)
+    handled := make(chan bool)
+    go func() {
+        handled <- c.handle(&sarama.ConsumerMessage{Topic: "orders"})
+    }()
+
+    // the message waits for a handler instead of failing
+    time.Sleep(20 * time.Millisecond)
+    calls := 0
+    c.mu.Lock()
+    c.handlers["orders"] = func(msg *Message) error {
+        calls++
+        return nil
+    }
+    c.mu.Unlock()
+    if !<-handled || calls != 1 || len(dlq.sent) != 0 {
+        t.Fatalf("expect the message handled once registered, calls %d, dead letters %d", calls, len(dlq.sent))
+    }
+
+    // closed while waiting, the offset must not be marked
+    c = newTestConsumer(dlq)
+    go func() {
+        handled <- c.handle(&sarama.ConsumerMessage{Topic: "orders"})
+    }()
+    close(c.quit)
+    if <-handled || len(dlq.sent) != 0 {
+        t.Fatal("expect a message without handler not to be marked")
+    }
+}
+
+func TestConsumerHandleFallback(t *testing.T) {
+    c := newTestConsumer(nil)
+    var got *Message
+    c.fallback = func(msg *Message) error {
+        got = msg
+        return nil
+    }
+
+    msg := &sarama.ConsumerMessage{
+        Topic: "orders",
+        Value: []byte("v"),
+        Headers: []*sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("t1")}},
+    }
+    if !c.handle(msg) || got == nil || string(got.Value) != "v" || string(got.Headers["trace"]) != "t1" {
+        t.Fatalf("expect the fallback to handle the message, got %+v", got)
+    }
+}
+
+func TestConsumerStart(t *testing.T) {
+    c := newTestConsumer(nil)
+    if err := c.start(); err == nil {
+        t.Fatal("expect a consumer without handlers not to start")
+    }
+
+    c.handlers["orders"] = func(msg *Message) error { return nil }
+    c.started = true
+    if err := c.start(); err == nil {
+        t.Fatal("expect a started consumer not to start again")
+    }
+}
+
+// ignoreErrors drops the complaints of a mock about unused expectations
+type ignoreErrors struct{}
+
+func (ignoreErrors) Errorf(string, ...interface{}) {}
+
+// newTestProducer runs a producer on a mock and makes it the producer of Put*
+func newTestProducer(t *testing.T, reporter mocks.ErrorReporter) (*producer, *mocks.AsyncProducer) {
+    conf := sarama.NewConfig()
+    conf.Producer.Return.Successes = true
+    mock := mocks.NewAsyncProducer(reporter, conf)
+
+    p := &producer{
+        inputChan: make(chan *sarama.ProducerMessage, 16),
+        quit: make(chan bool),
+        closed: make(chan struct{}),
+        wg: &sync.WaitGroup{},
+    }
+    p.start(mock)
+    cli = &client{producer: p}
+    t.Cleanup(func() {
+        select {
+        case <-p.quit:
+        default:
+            p.close()
+        }
+        <-p.closed
+        cli = nil
+    })
+    return p, mock
+}
+
+func TestProducerPut(t *testing.T) {
+    errBroker := errors.New("broker unavailable")
+    p, mock := newTestProducer(t, t)
+    ctx := context.Background()
+
+    mock.ExpectInputAndSucceed()
+    if _, offset, err := PutSync(ctx, "orders", "k", map[string]int{"id": 1}); err != nil || offset != 1 {
+        t.Fatalf("expect the message written at offset 1, got %d %v", offset, err)
+    }
+
+    mock.ExpectInputAndFail(errBroker)
+    if _, _, err := PutSync(ctx, "orders", "k", map[string]int{"id": 2}); err != errBroker {
+        t.Fatalf("expect the broker error, got %v", err)
+    }
+
+    mock.ExpectInputAndSucceed()
+    result := make(chan error, 1)
+    err := PutAsync(ctx, "orders", "", map[string]int{"id": 3}, func(partition int32, offset int64, err error) {
+        result <- err
+    })
+    if err != nil || <-result != nil {
+        t.Fatalf("expect the callback to get the result, got %v", err)
+    }
+
+    p.close()
+    <-p.closed
+    if err := PutAsync(ctx, "orders", "", map[string]int{"id": 4}, nil); err != ErrProducerClosed {
+        t.Fatalf("expect ErrProducerClosed from PutAsync, got %v", err)
+    }
+    if _, _, err := PutSync(ctx, "orders", "", map[string]int{"id": 5}); err != ErrProducerClosed {
+        t.Fatalf("expect ErrProducerClosed from PutSync, got %v", err)
+    }
+}
+
+func TestProducerSendTimeout(t *testing.T) {
+    // nothing reads the queue
+    p := &producer{inputChan: make(chan *sarama.ProducerMessage), quit: make(chan bool), closed: make(chan struct{})}
+    cli = &client{producer: p}
+    defer func() { cli = nil }()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if _, _, err := PutSync(ctx, "orders", "", "v"); err != context.DeadlineExceeded {
+        t.Fatalf("expect the queue wait to time out, got %v", err)
+    }
+}
+
+func TestProducerCloseWhileSending(t *testing.T) {
+    const senders = 200
+    p, mock := newTestProducer(t, ignoreErrors{})
+    for i := 0; i < senders; i++ {
+        mock.ExpectInputAndSucceed()
+    }
+
+    var queued, callbacks int32
+    var wg sync.WaitGroup
+    for i := 0; i < senders; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            err := PutAsync(context.Background(), "orders", "", i, func(partition int32, offset int64, err error) {
+                atomic.AddInt32(&callbacks, 1)
+            })
+            if err == nil {
+                atomic.AddInt32(&queued, 1)
+            }
+        }(i)
+        if i == senders/2 {
+            p.close()
+        }
+    }
+    wg.Wait()
+    <-p.closed
+
+    // every queued message gets its callback, either the result or ErrProducerClosed
+    if queued != callbacks {
+        t.Fatalf("expect a callback for each of the %d queued messages, got %d", queued, callbacks)
+    }
+}
@@ -1 +1,29 @@
 package toolkit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+// NewUUID returns a random (version 4) uuid like 0b7a6c0e-5d3f-4a8e-9c1d-2f4e6a8b0c1d
+func NewUUID() string {
+	var u [16]byte
+	if _, err := io.ReadFull(rand.Reader, u[:]); err != nil {
+		panic("toolkit: read random failed, " + err.Error())
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}